@@ -0,0 +1,41 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// EnableAnonymousTempFiles makes temp files anonymous: they have no name, so they can't be seen by other
+// users and their disk space is freed automatically when the process dies. On Linux, files are created with
+// O_TMPFILE flag if the filesystem supports it. Otherwise, files are removed right after the creation.
+// Data is read through the same descriptor.
+//
+// Anonymous temp files aren't supported on Windows
+func (b *Buffer) EnableAnonymousTempFiles() error {
+	if runtime.GOOS == "windows" {
+		return errors.New("anonymous temp files aren't supported on windows")
+	}
+
+	b.anonymousFiles = true
+
+	return nil
+}
+
+// createUnlinkedFile creates a temp file and removes it right away
+func createUnlinkedFile(dir string) (*os.File, error) {
+	file, err := ioutil.TempFile(dir, "go-disk-buffer-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Remove(file.Name())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,23 @@
+package buffer
+
+import (
+	"os"
+	"syscall"
+)
+
+// oTmpFile is O_TMPFILE flag. syscall package doesn't have it
+const oTmpFile = 0x400000 | syscall.O_DIRECTORY
+
+// createAnonymousFile creates a file with O_TMPFILE flag. If the filesystem doesn't support
+// this flag, it falls back to createUnlinkedFile
+func createAnonymousFile(dir string) (*os.File, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	file, err := os.OpenFile(dir, os.O_RDWR|oTmpFile, 0600)
+	if err != nil {
+		return createUnlinkedFile(dir)
+	}
+	return file, nil
+}
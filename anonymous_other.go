@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package buffer
+
+import (
+	"os"
+)
+
+func createAnonymousFile(dir string) (*os.File, error) {
+	return createUnlinkedFile(dir)
+}
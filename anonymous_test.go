@@ -0,0 +1,126 @@
+//go:build !windows
+// +build !windows
+
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_EnableAnonymousTempFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+		wipe    bool
+	}{
+		{name: "plain"},
+		{name: "encrypted", encrypt: true},
+		{name: "plain with wipe", wipe: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+			require.Nil(err)
+			defer os.RemoveAll(dir)
+
+			b := NewBufferWithMaxMemorySize(10)
+			require.Nil(b.ChangeTempDir(dir))
+			require.Nil(b.EnableAnonymousTempFiles())
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			if tt.wipe {
+				b.EnableSecureWipe(true)
+			}
+			defer b.Close()
+
+			data := generateRandomString(1 << 17)
+			_, err = b.Write([]byte(data))
+			require.Nil(err)
+			require.NotNil(b.file)
+
+			files, err := ioutil.ReadDir(dir)
+			require.Nil(err)
+			require.Len(files, 0, "temp file must not be visible")
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, string(res))
+			require.Nil(b.Close())
+		})
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+		require.Nil(err)
+		defer os.RemoveAll(dir)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.ChangeTempDir(dir))
+		require.Nil(b.EnableAnonymousTempFiles())
+		require.Nil(b.EnableEncryption())
+		defer b.Close()
+
+		data := generateRandomString(1 << 17)
+		_, err = b.WriteString(data)
+		require.Nil(err)
+
+		path := filepath.Join(dir, "result")
+		require.Nil(b.CommitTo(path, 0640))
+
+		res, err := ioutil.ReadFile(path)
+		require.Nil(err)
+		require.Equal(data, string(res))
+
+		info, err := os.Stat(path)
+		require.Nil(err)
+		require.Equal(os.FileMode(0640), info.Mode().Perm())
+
+		files, err := ioutil.ReadDir(dir)
+		require.Nil(err)
+		require.Len(files, 1)
+	})
+	t.Run("commit with rate limiter", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+		require.Nil(err)
+		defer os.RemoveAll(dir)
+
+		b := NewBufferWithMaxMemorySize(0)
+		require.Nil(b.ChangeTempDir(dir))
+		require.Nil(b.EnableAnonymousTempFiles())
+		defer b.Close()
+
+		data := generateRandomString(50 << 10)
+		_, err = b.WriteString(data)
+		require.Nil(err)
+
+		// The anonymous file is copied: both reads and writes must be limited
+		b.SetRateLimiter(newRateLimiter(t, 1<<20, 1<<10))
+		path := filepath.Join(dir, "result")
+		require.Nil(b.CommitTo(path, 0644))
+		require.True(b.RateLimitWait() > 50*time.Millisecond)
+
+		res, err := ioutil.ReadFile(path)
+		require.Nil(err)
+		require.Equal(data, string(res))
+	})
+}
@@ -2,11 +2,14 @@ package buffer
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 	"unicode/utf8"
 
 	"github.com/minio/sio"
@@ -16,39 +19,130 @@ import (
 const (
 	// DefaultMaxMemorySize is used when Buffer is created with NewBuffer() or NewBufferString()
 	DefaultMaxMemorySize = 2 << 20 // 2 MB
+
+	// encryptionPayloadSize is a size of a payload of a single encrypted package
+	encryptionPayloadSize = 64 << 10 // 64 KB
+	// encryptedPackageSize is a size of a single encrypted package (header + payload + tag)
+	encryptedPackageSize = 16 + encryptionPayloadSize + 16
+	// encryptionKeySize is a size of an encryption key
+	encryptionKeySize = 32
+
+	// maxInt is the max value of int. It is 1<<31 - 1 on 32-bit platforms
+	maxInt = int(^uint(0) >> 1)
 )
 
 var (
 	// ErrBufferFinished is used when Buffer.Write() method is called after Buffer.Read()
 	ErrBufferFinished = errors.New("buffer is finished")
+
+	// ErrTruncateOutOfRange is used when Buffer.Truncate() is called with n < 0 or n > Buffer.Len()
+	ErrTruncateOutOfRange = errors.New("truncation out of range")
+
+	// ErrUnreadByte is used when Buffer.UnreadByte() is called not after a successful read
+	ErrUnreadByte = errors.New("previous operation was not a successful read")
+
+	// ErrNegativeCount is used when Buffer.Peek() is called with negative n
+	ErrNegativeCount = errors.New("negative count")
+
+	// ErrWriteAtOutOfRange is used when Buffer.WriteAt() is called for bytes that weren't written yet
+	ErrWriteAtOutOfRange = errors.New("write out of range")
+
+	// ErrWriteAtEncrypted is used when Buffer.WriteAt() is called for encrypted bytes stored on a disk
+	ErrWriteAtEncrypted = errors.New("can't overwrite encrypted data")
+
+	// ErrRingModeUnsupported is used when an operation or an option isn't supported in ring mode
+	ErrRingModeUnsupported = errors.New("unsupported in ring mode")
 )
 
 // Buffer is a buffer which can store data on a disk. It isn't thread-safe!
 type Buffer struct {
 	maxInMemorySize int
+	// spillPolicy overrides maxInMemorySize if it isn't nil
+	spillPolicy SpillPolicy
 
 	writingFinished bool
-	readingFinished bool
+	// lastRead is true when the last operation was a successful read. It is used by Buffer.UnreadByte()
+	lastRead bool
 
-	size   int
-	offset int
+	// size is a number of written bytes
+	size int64
+	// offset is a number of read bytes
+	offset int64
 
 	// tempFileDir is a directory for temp files. It is empty by default (so, "ioutil.TempFile" uses os.TempDir)
 	tempFileDir string
 
-	encrypt       bool
-	encryptionKey [32]byte
-
-	// buff is used to store data in memory
+	encrypt bool
+	// encryptionKey is shared with fileReader. It is stored in lockedMemory if memory lock is enabled
+	encryptionKey []byte
+	// encryptionKeyWiped is true when the key was zeroed. A new key must be generated before the usage
+	encryptionKeyWiped bool
+
+	// secureWipe enables zeroing of the memory and the encryption key
+	secureWipe bool
+	// wipeFile enables overwriting of plain temp files before removal
+	wipeFile bool
+
+	// fileHeader enables headers of temp files
+	fileHeader bool
+	// anonymousFiles enables anonymous temp files
+	anonymousFiles bool
+	// tempFileCreator is used to create temp files instead of ioutil.TempFile if it isn't nil
+	tempFileCreator TempFileCreator
+	// spillDirs overrides tempFileDir if it isn't nil
+	spillDirs *SpillDirs
+
+	// reuseTempFile enables reuse of the temp file after Reset
+	reuseTempFile bool
+	// spareFile is an empty temp file kept for the next spill
+	spareFile *tempFile
+
+	// syncPolicy decides when the temp file is synced
+	syncPolicy SyncPolicy
+	// unsyncedSize is a number of bytes written into the file after the last sync
+	unsyncedSize int64
+	// sizeHint is an expected number of bytes to be written. It is 0 when it is unknown
+	sizeHint int64
+
+	// hashes are updated with all written bytes
+	hashes map[string]hash.Hash
+	// hashesOutdated is true when written bytes were changed. Hashes must be computed again
+	hashesOutdated bool
+
+	// rateLimiter limits reading and writing of the file
+	rateLimiter *RateLimiter
+	// rateLimitWait is the total time spent waiting for rateLimiter
+	rateLimitWait time.Duration
+	// ctx is a context of the current context-aware operation. It is used by rateLimiter
+	ctx context.Context
+
+	// buff is used to store data in memory. Read() doesn't drain it, data is accessed by offset
 	buff bytes.Buffer
 
-	// writeFile is used to write the data on a disk
-	writeFile io.WriteCloser
-	// readFile is used to read the data from a disk
-	readFile io.ReadCloser
-
-	useFile  bool
+	// file is used to write and read the data on a disk. It can be shared with Readers
+	file     *tempFile
 	filename string
+	// fileSize is a number of bytes (before encryption) stored in the file
+	fileSize int64
+
+	// encryptWriter is used to encrypt the data before writing it into the file
+	encryptWriter io.WriteCloser
+
+	// fileReader is used to read the data from the file
+	fileReader fileReader
+
+	// memoryShared is true when the memory is used by Readers. It must not be reused after Reset()
+	memoryShared bool
+
+	// lockedMemory stores the encryption key and the memory if memory lock is enabled
+	lockedMemory *lockedMemory
+	// lockedPackage stores the last decrypted package if memory lock and encryption are enabled
+	lockedPackage *lockedMemory
+
+	// ringLimit is a max number of retained bytes in ring mode. Ring mode is disabled when it is 0
+	ringLimit int64
+	// ringMemory is used to store the newest bytes in ring mode
+	ringMemory []byte
 }
 
 // NewBufferWithMaxMemorySize creates a new Buffer with passed maxInMemorySize
@@ -88,44 +182,90 @@ func NewBufferString(s string) *Buffer {
 
 // ChangeTempDir changes directory for temp files
 func (b *Buffer) ChangeTempDir(dir string) error {
+	path, err := checkDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Change
+	b.tempFileDir = path
+
+	return nil
+}
+
+// checkDir checks that dir is a directory and returns its absolute path
+func checkDir(dir string) (string, error) {
 	f, err := os.Open(dir)
 	if err != nil {
-		return errors.Wrapf(err, "can't open directory '%s'", dir)
+		return "", errors.Wrapf(err, "can't open directory '%s'", dir)
 	}
 	defer f.Close()
 
 	stats, err := f.Stat()
 	if err != nil {
-		return errors.Wrapf(err, "can't get stats of the directory '%s'", dir)
+		return "", errors.Wrapf(err, "can't get stats of the directory '%s'", dir)
 	}
 	if !stats.IsDir() {
-		return errors.Errorf("'%s' is not a directory", dir)
+		return "", errors.Errorf("'%s' is not a directory", dir)
 	}
 
 	path, err := filepath.Abs(dir)
 	if err != nil {
-		return errors.New("can't get an absolute path")
+		return "", errors.New("can't get an absolute path")
 	}
 
-	// Change
-	b.tempFileDir = path
+	return path, nil
+}
 
-	return nil
+// SetTempFileCreator sets a function that creates temp files instead of ioutil.TempFile. It can be used
+// to wrap temp files, for example, to inject faults in tests (see package bufferstest). The function
+// overrides Buffer.EnableAnonymousTempFiles(). Pass nil to use ioutil.TempFile again
+func (b *Buffer) SetTempFileCreator(fn TempFileCreator) {
+	b.tempFileCreator = fn
+}
+
+// SetSpillPolicy sets a policy that decides how many bytes can be stored in memory. The policy
+// is consulted by Buffer.Write() until the Buffer starts to use a temp file. The policy isn't used
+// in ring mode. Pass nil to use maxInMemorySize again
+func (b *Buffer) SetSpillPolicy(policy SpillPolicy) {
+	b.spillPolicy = policy
+}
+
+// maxMemorySize returns a max number of bytes that can be stored in memory
+func (b *Buffer) maxMemorySize() int {
+	size := b.maxInMemorySize
+	if b.spillPolicy != nil {
+		size = b.spillPolicy.MaxMemorySize()
+	}
+	if b.lockedMemory != nil && size > b.buff.Cap() {
+		// Locked memory can't grow
+		size = b.buff.Cap()
+	}
+	return size
 }
 
 // EnableEncryption enables encryption and generates an encryption key
 func (b *Buffer) EnableEncryption() error {
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
+	}
+
 	b.encrypt = true
 
-	key := make([]byte, len(b.encryptionKey))
-	_, err := rand.Read(key)
-	if err != nil {
-		return errors.Wrap(err, "can't read random data")
+	return b.generateEncryptionKey()
+}
+
+func (b *Buffer) generateEncryptionKey() error {
+	if b.encryptionKey == nil {
+		b.encryptionKey = make([]byte, encryptionKeySize)
 	}
 
-	for i := range key {
-		b.encryptionKey[i] = key[i]
+	// Read directly into the slice to avoid copies of the key
+	_, err := rand.Read(b.encryptionKey)
+	if err != nil {
+		return errors.Wrap(err, "can't read random data")
 	}
+	b.encryptionKeyWiped = false
 
 	return nil
 }
@@ -138,21 +278,38 @@ func (b *Buffer) Write(data []byte) (n int, err error) {
 		return 0, ErrBufferFinished
 	}
 
+	b.lastRead = false
+
+	if len(b.hashes) != 0 {
+		defer func(data []byte) {
+			b.writeHashes(data[:n])
+		}(data)
+	}
+
+	if b.ringLimit != 0 {
+		return b.writeToRing(data)
+	}
+
 	defer func() {
-		b.size += n
+		b.size += int64(n)
 	}()
 
-	if !b.useFile {
-		if b.buff.Len()+len(data) <= b.maxInMemorySize {
+	if b.file == nil {
+		maxInMemorySize := b.maxMemorySize()
+		if b.buff.Len()+len(data) <= maxInMemorySize {
 			// Just write data into the buffer
-			n, err = b.buff.Write(data)
+			n, err = b.writeToMemory(data)
 			return
 		}
 
 		// We have to use a file. But fill the buffer at first
 
-		bound := b.maxInMemorySize - b.buff.Len()
-		n, err = b.buff.Write(data[:bound])
+		bound := maxInMemorySize - b.buff.Len()
+		if bound < 0 {
+			// Spill policy has decreased the size
+			bound = 0
+		}
+		n, err = b.writeToMemory(data[:bound])
 		if err != nil {
 			return
 		}
@@ -160,31 +317,273 @@ func (b *Buffer) Write(data []byte) (n int, err error) {
 		// Trim written bytes
 		data = data[bound:]
 
-		b.useFile = true
+		err = b.createTempFile()
+		if err != nil {
+			return n, err
+		}
 
-		// Create a temporary file
-		file, err := ioutil.TempFile(b.tempFileDir, "go-disk-buffer-*.tmp")
+		// fallthrough
+	}
+
+	// Write data into the file
+	n1, err := b.writeToFile(data)
+	n += n1
+	return
+}
+
+func (b *Buffer) createTempFile() error {
+	if b.encrypt && (b.encryptionKeyWiped || b.spareFile != nil) {
+		// A reused file gets a new key
+		err := b.generateEncryptionKey()
 		if err != nil {
-			return n, errors.Wrap(err, "can't create a temp file")
+			return err
 		}
+	}
+
+	var required int64
+	if b.ringLimit != 0 {
+		required = b.ringFileSize()
+	} else if rest := b.sizeHint - int64(b.buff.Len()); rest > 0 {
+		// Bytes that are stored in memory don't need space on a disk
+		required = b.physicalSize(rest)
+	}
+	if b.fileHeader {
+		required += FileHeaderSize
+	}
 
-		var writeFile io.WriteCloser = file
+	if b.encrypt && b.lockedMemory != nil && b.lockedPackage == nil {
+		// Decrypted data mustn't be swapped
+		mem, err := lockMemory(encryptionPayloadSize)
+		if err != nil {
+			return err
+		}
+		b.lockedPackage = mem
+	}
+
+	file, err := b.reuseOrOpenTempFile(required)
+	if err != nil {
+		return err
+	}
+
+	b.file = file
+	b.file.wipe = b.wipeFile && !b.encrypt
+	b.filename = file.Name()
+	b.fileReader = fileReader{
+		file:          b.file,
+		encrypt:       b.encrypt,
+		encryptionKey: b.encryptionKey,
+		waitIO:        b.waitRateLimiter,
+	}
+	if b.encrypt && b.lockedPackage != nil {
+		b.fileReader.packageBuffer = b.lockedPackage.data
+	}
+
+	err = b.initTempFile()
+	if err != nil {
+		// The file mustn't be used. For example, data must never be written into a file without
+		// the encryption stream if encryption is enabled
+		b.file.release()
+		b.file = nil
+		b.filename = ""
+		b.fileReader = fileReader{
+			waitIO: b.waitRateLimiter,
+		}
+		return err
+	}
+
+	return nil
+}
+
+// initTempFile writes the header of the new temp file and creates the encryption stream if needed
+func (b *Buffer) initTempFile() (err error) {
+	if b.fileHeader {
+		b.fileReader.headerSize = FileHeaderSize
 		if b.encrypt {
-			writeFile, err = sio.EncryptWriter(file, sio.Config{Key: b.encryptionKey[:]})
-			if err != nil {
-				return n, errors.Wrap(err, "can't create an encryption stream")
-			}
+			// The cipher suite is written into the header, so it must be known
+			b.fileReader.cipherSuites = []byte{sioDefaultCipherSuite()}
 		}
-		b.writeFile = writeFile
-		b.filename = file.Name()
 
-		// fallthrough
+		err = b.writeFileHeader()
+		if err != nil {
+			return err
+		}
+		_, err = b.file.Seek(FileHeaderSize, io.SeekStart)
+		if err != nil {
+			return errors.Wrapf(err, "can't seek a temp file '%s'", b.filename)
+		}
 	}
 
-	// Write data into the file
-	n1, err := b.writeFile.Write(data)
+	if b.encrypt {
+		b.encryptWriter, err = b.newEncryptWriter(0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reuseOrOpenTempFile returns the spare file if it exists. Otherwise, it creates a new temp file.
+// Disk space is preallocated for required bytes
+func (b *Buffer) reuseOrOpenTempFile(required int64) (*tempFile, error) {
+	if b.spareFile == nil {
+		return b.openTempFile(required)
+	}
+
+	file := b.spareFile
+	b.spareFile = nil
+
+	err := preallocateTempFile(file, 0, required)
+	if err != nil {
+		file.release()
+		return nil, err
+	}
+	return file, nil
+}
+
+// openTempFile creates a new temp file and preallocates disk space for required bytes. If spill
+// directories are set, the file is created in the first suitable directory
+func (b *Buffer) openTempFile(required int64) (*tempFile, error) {
+	if b.spillDirs == nil {
+		return b.openTempFileIn(b.tempFileDir, required)
+	}
+
+	dirs, err := b.spillDirs.candidates(b.spillDirsRequirement(required))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		file, err := b.openTempFileIn(dir, required)
+		if isNoSpaceError(err) {
+			// Try the next directory
+			continue
+		}
+		return file, err
+	}
+
+	return nil, ErrInsufficientSpace
+}
+
+// openTempFileIn creates a new temp file in passed directory and preallocates disk space for required bytes
+func (b *Buffer) openTempFileIn(dir string, required int64) (*tempFile, error) {
+	file, err := b.createFile(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = preallocateTempFile(file, 0, required)
+	if err != nil {
+		file.release()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// createFile creates a new temp file in passed directory
+func (b *Buffer) createFile(dir string) (*tempFile, error) {
+	if b.tempFileCreator != nil {
+		file, err := b.tempFileCreator(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't create a temp file")
+		}
+		return newTempFile(file), nil
+	}
+
+	if b.anonymousFiles {
+		file, err := createAnonymousFile(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't create an anonymous temp file")
+		}
+
+		f := newTempFile(file)
+		f.anonymous = true
+		return f, nil
+	}
+
+	file, err := ioutil.TempFile(dir, "go-disk-buffer-*.tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create a temp file")
+	}
+	return newTempFile(file), nil
+}
+
+func (b *Buffer) writeToFile(data []byte) (n int, err error) {
+	err = b.waitRateLimiter(len(data))
+	if err != nil {
+		return 0, err
+	}
+
+	if b.encryptWriter != nil {
+		n, err = b.encryptWriter.Write(data)
+	} else {
+		n, err = b.file.Write(data)
+	}
+	b.fileSize += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, b.fileWritten(n)
+}
+
+// newEncryptWriter returns a writer that encrypts data and writes it into the file.
+// The first package gets passed sequence number
+func (b *Buffer) newEncryptWriter(seqNum uint32) (io.WriteCloser, error) {
+	// Hide Close method of the file: the file is closed by Buffer
+	w, err := sio.EncryptWriter(struct{ io.Writer }{b.file}, encryptionConfig(b.encryptionKey, seqNum, b.fileReader.cipherSuites))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create an encryption stream")
+	}
+	return w, nil
+}
+
+// WriteAt overwrites len(data) already written bytes starting at offset off. The bytes can be stored
+// both in memory and on a disk. WriteAt can't extend the Buffer: it returns ErrWriteAtOutOfRange if
+// off+len(data) is greater than the number of written bytes.
+//
+// WriteAt returns ErrWriteAtEncrypted if encryption is enabled and the bytes are stored on a disk.
+// WriteAt returns ErrBufferFinished after the call of Buffer.Read(), Buffer.ReadByte() or Buffer.Next()
+func (b *Buffer) WriteAt(data []byte, off int64) (n int, err error) {
+	if b.writingFinished {
+		return 0, ErrBufferFinished
+	}
+	if b.ringLimit != 0 {
+		return 0, ErrRingModeUnsupported
+	}
+	if off < 0 || off+int64(len(data)) > b.size {
+		return 0, ErrWriteAtOutOfRange
+	}
+
+	b.lastRead = false
+
+	memorySize := int64(b.buff.Len())
+	if b.encrypt && off+int64(len(data)) > memorySize {
+		return 0, ErrWriteAtEncrypted
+	}
+
+	if off < memorySize {
+		n = copy(b.buff.Bytes()[off:], data)
+		data = data[n:]
+		off += int64(n)
+		b.invalidateHashes()
+	}
+	if len(data) == 0 {
+		return n, nil
+	}
+
+	err = b.waitRateLimiter(len(data))
+	if err != nil {
+		return n, err
+	}
+
+	n1, err := b.file.WriteAt(data, b.fileReader.headerSize+off-memorySize)
 	n += n1
-	return
+	b.invalidateHashes()
+	if err != nil {
+		return n, errors.Wrapf(err, "can't write into a temp file '%s'", b.filename)
+	}
+	return n, nil
 }
 
 // WriteByte writes a single byte.
@@ -216,122 +615,79 @@ func (b *Buffer) WriteString(s string) (n int, err error) {
 
 // ReadFrom reads data from r until EOF and writes it into the Buffer.
 func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
-	var n int64
-
-	var data = make([]byte, 512)
-	for {
-		rN, rErr := r.Read(data)
-		if rErr != nil && rErr != io.EOF {
-			return n, errors.Wrap(rErr, "can't read data from passed io.Reader")
-		}
-
-		data = data[:rN]
-		wN, wErr := b.Write(data)
-		if wErr != nil {
-			return n + int64(wN), errors.Wrap(wErr, "can't write data")
-		}
-		n += int64(rN)
-
-		if rErr == io.EOF {
-			return n, nil
-		}
-
-		data = data[:cap(data)]
-	}
+	return b.ReadFromContext(context.Background(), r)
 }
 
 // Read reads data from bytes.Buffer or from a file. A temp file is deleted when Read() encounter n == 0
 func (b *Buffer) Read(data []byte) (n int, err error) {
-	if b.readingFinished {
-		return 0, io.EOF
-	}
-
-	if !b.writingFinished {
-		// Finish writing and close Write&Read file if needed
-		if b.writeFile != nil {
-			b.writeFile.Close()
-			b.writeFile = nil
-		}
-		b.writingFinished = true
+	err = b.finishWriting()
+	if err != nil {
+		return 0, err
 	}
 
-	// Check if reading is finished
-	defer func() {
-		b.offset += n
-
-		// If n is less than size of data slice, reading is finished
-		if n < len(data) {
-			b.readingFinished = true
-		}
+	b.lastRead = false
 
-		if b.readingFinished && b.readFile != nil {
-			// Can close the file
-			b.readFile.Close()
-			os.Remove(b.filename)
+	if b.Len() == 0 {
+		// Reading is finished
+		b.removeTempFile()
 
-			b.readFile = nil
-			b.filename = ""
+		if len(data) == 0 {
+			return 0, nil
 		}
-	}()
-
-	if b.buff.Len() != 0 {
-		// Use the buffer
-		n, err = b.readFromBuffer(data)
-		if err != nil || n == len(data) || !b.useFile {
-			// Return if got an error, we filled the slice with data from buffer or we don't use a file
-			return
-		}
-
-		// Can use the file to fill the slice
-
-		var n1 int
-
-		temp := make([]byte, len(data)-n)
-		n1, err = b.readFromFile(temp)
-		temp = temp[:n1]
-		copy(data[n:], temp)
-
-		n += n1
-		return
+		return 0, io.EOF
 	}
 
-	if b.useFile {
-		// Use the file
-		n, err = b.readFromFile(data)
-		return
+	n, err = b.readAt(data, b.offset)
+	b.offset += int64(n)
+	if n > 0 {
+		b.lastRead = true
 	}
 
-	// Reaching this code means that we buffer is empty and we don't use a file. So, reading is finished
-
-	n = 0
-	err = io.EOF
-	return
+	return n, err
 }
 
-func (b *Buffer) readFromBuffer(data []byte) (n int, err error) {
-	return b.buff.Read(data)
-}
+// finishWriting finishes writing and flushes the encryption stream if needed
+func (b *Buffer) finishWriting() error {
+	if b.writingFinished {
+		return nil
+	}
+	b.writingFinished = true
 
-func (b *Buffer) readFromFile(data []byte) (n int, err error) {
-	if b.readFile == nil {
-		file, err := os.Open(b.filename)
+	if b.encryptWriter != nil {
+		err := b.encryptWriter.Close()
+		b.encryptWriter = nil
 		if err != nil {
-			return 0, errors.Wrapf(err, "can't open a temp file '%s'", b.filename)
+			return errors.Wrap(err, "can't flush the encryption stream")
 		}
+	}
 
-		var readFile io.ReadCloser = file
-		if b.encrypt {
-			reader, err := sio.DecryptReader(file, sio.Config{Key: b.encryptionKey[:]})
-			if err != nil {
-				return 0, errors.Wrap(err, "can't create a decryption stream")
-			}
-			readFile = newSioDecryptReaderWrapper(reader, file)
+	if b.file != nil {
+		err := b.writeFileHeader()
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.syncPolicy != SyncNever && b.file != nil {
+		err := b.syncFile()
+		if err != nil {
+			return err
 		}
+	}
+
+	return b.updateHashes()
+}
 
-		b.readFile = readFile
+// readAt reads data starting at passed offset. It never reads more than size of the Buffer
+func (b *Buffer) readAt(data []byte, off int64) (n int, err error) {
+	if rest := b.size - off; int64(len(data)) > rest {
+		data = data[:rest]
+	}
+	if b.ringLimit != 0 {
+		return b.readFromRing(data, off)
 	}
 
-	return b.readFile.Read(data)
+	return readAt(b.buff.Bytes(), &b.fileReader, data, off)
 }
 
 // ReadByte reads a single byte.
@@ -366,44 +722,72 @@ func (b *Buffer) readRune() (r rune, size int, err error) {
 // Next returns a slice containing the next n bytes from the buffer.
 // If an error occurred, it panics
 func (b *Buffer) Next(n int) []byte {
+	if l := b.Len(); n > l {
+		n = l
+	}
+
 	slice := make([]byte, n)
-	n, err := b.buff.Read(slice)
-	if err != nil {
+	n, err := b.Read(slice)
+	if err != nil && err != io.EOF {
 		panic(err)
 	}
 	slice = slice[:n]
 	return slice
 }
 
-// WriteTo writes data to w until the buffer is drained or an error occurs.
-func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
-	var n int64
+// Peek returns the next n bytes without advancing the reader. The bytes can be stored both in memory
+// and on a disk. If Peek returns fewer than n bytes, it also returns io.EOF.
+//
+// Peek finishes writing as Buffer.Read() does
+func (b *Buffer) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
 
-	data := make([]byte, 512)
-	for {
-		rN, rErr := b.Read(data)
-		if rErr != nil && rErr != io.EOF {
-			return n, errors.Wrap(rErr, "can't read data from Buffer")
-		}
+	err := b.finishWriting()
+	if err != nil {
+		return nil, err
+	}
 
-		data = data[:rN]
-		wN, wErr := w.Write(data)
-		if wErr != nil {
-			return n + int64(wN), errors.Wrap(wErr, "can't write data into io.Writer")
-		}
-		n += int64(rN)
+	var tooShort bool
+	if l := b.Len(); n > l {
+		n = l
+		tooShort = true
+	}
 
-		if rErr == io.EOF {
-			return n, nil
-		}
+	slice := make([]byte, n)
+	n, err = b.readAt(slice, b.offset)
+	if err != nil {
+		return slice[:n], err
+	}
+	if tooShort {
+		return slice[:n], io.EOF
+	}
+	return slice[:n], nil
+}
 
-		data = data[:cap(data)]
+// UnreadByte unreads the last byte returned by the most recent successful read operation
+// that read at least one byte. The byte can be stored both in memory and on a disk
+func (b *Buffer) UnreadByte() error {
+	if !b.lastRead {
+		return ErrUnreadByte
 	}
+
+	b.lastRead = false
+	b.offset--
+
+	return nil
+}
+
+// WriteTo writes data to w until the buffer is drained or an error occurs.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	return b.WriteToContext(context.Background(), w)
 }
 
-// Len returns the number of bytes of the unread portion of the buffer
+// Len returns the number of bytes of the unread portion of the buffer. It saturates at the max int value
+// if the unread portion doesn't fit in int (on 32-bit platforms). Use Buffer.Remaining() for large buffers
 func (b *Buffer) Len() int {
-	return b.size - b.offset
+	return saturateInt(b.Remaining())
 }
 
 // Cap is equal to Buffer.Len()
@@ -411,48 +795,250 @@ func (b *Buffer) Cap() int {
 	return b.Len()
 }
 
-// Reset resets buffer and remove file if needed
-func (b *Buffer) Reset() {
-	b.buff.Reset()
+// Size returns the number of written bytes, including read ones. In ring mode discarded bytes are counted too
+func (b *Buffer) Size() int64 {
+	return b.size
+}
+
+// Remaining returns the number of bytes of the unread portion of the buffer
+func (b *Buffer) Remaining() int64 {
+	return b.size - b.offset
+}
+
+// saturateInt converts n to int. It returns the max int value if n doesn't fit in int
+func saturateInt(n int64) int {
+	if n > int64(maxInt) {
+		return maxInt
+	}
+	return int(n)
+}
 
-	if b.writeFile != nil {
-		b.writeFile.Close()
+// Truncate discards all but the first n unread bytes from the buffer. If the tail is stored on a disk,
+// the temp file is truncated. Encrypted file can be truncated only by whole packages, so the last package
+// is encrypted again.
+//
+// Truncate returns ErrTruncateOutOfRange if n is negative or greater than Buffer.Len().
+// Use Buffer.TruncateRemaining() for buffers larger than 2 GB on 32-bit platforms
+func (b *Buffer) Truncate(n int) error {
+	return b.TruncateRemaining(int64(n))
+}
+
+// TruncateRemaining is like Buffer.Truncate(), but n is int64. It returns ErrTruncateOutOfRange
+// if n is negative or greater than Buffer.Remaining()
+func (b *Buffer) TruncateRemaining(n int64) error {
+	if n == 0 {
+		b.Reset()
+		return nil
+	}
+
+	b.lastRead = false
+
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
 	}
-	if b.readFile != nil {
-		b.readFile.Close()
+	if n < 0 || n > b.Remaining() {
+		return ErrTruncateOutOfRange
 	}
+	if n == b.Remaining() {
+		return nil
+	}
+
+	newSize := b.offset + n
 
-	if b.filename != "" {
-		os.Remove(b.filename)
+	memorySize := int64(b.buff.Len())
+	if newSize <= memorySize {
+		// All remaining data is stored in memory. So, we don't need the file anymore
+		b.removeTempFile()
+		if b.secureWipe {
+			wipe(b.buff.Bytes()[newSize:])
+		}
+		b.buff.Truncate(int(newSize))
+	} else {
+		err := b.truncateFile(newSize - memorySize)
+		if err != nil {
+			return err
+		}
 	}
 
-	b.writingFinished = false
-	b.readingFinished = false
-	b.writeFile = nil
-	b.readFile = nil
-	b.useFile = false
-	b.filename = ""
+	b.size = newSize
+
+	b.invalidateHashes()
+	if b.writingFinished {
+		// Data can be removed by the next reads
+		return b.updateHashes()
+	}
+	return nil
+}
+
+// truncateFile truncates the file to passed size (before encryption)
+func (b *Buffer) truncateFile(size int64) error {
+	if b.file.shared() {
+		// The file is used by Readers, so it can't be modified. But writing is finished
+		// and Buffer never reads more than its size. So, we can just skip the truncation
+		return nil
+	}
+
+	headerSize := b.fileReader.headerSize
+
+	if !b.fileReader.encrypt {
+		if b.file.wipe {
+			err := wipeFileRange(b.file.TempFile, headerSize+size, headerSize+b.fileSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := b.file.Truncate(headerSize + size)
+		if err != nil {
+			return errors.Wrapf(err, "can't truncate a temp file '%s'", b.filename)
+		}
+		// Next writes must continue from the new end of the file
+		_, err = b.file.Seek(headerSize+size, io.SeekStart)
+		if err != nil {
+			return errors.Wrapf(err, "can't seek a temp file '%s'", b.filename)
+		}
+
+		b.fileSize = size
+		return b.truncatedFileHeader()
+	}
+
+	// Flush the last package
+	if b.encryptWriter != nil {
+		err := b.encryptWriter.Close()
+		b.encryptWriter = nil
+		if err != nil {
+			return errors.Wrap(err, "can't flush the encryption stream")
+		}
+	}
+
+	// Keep only whole packages and encrypt the rest of the last package again
+	index := size / encryptionPayloadSize
+
+	var tail []byte
+	if rest := size % encryptionPayloadSize; rest != 0 {
+		pkg, err := b.fileReader.decryptPackage(index)
+		if err != nil {
+			return err
+		}
+		if int64(len(pkg)) < rest {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "can't truncate a temp file '%s'", b.filename)
+		}
+		// The package isn't overwritten until the next read. So, the tail isn't copied:
+		// the package can be stored in locked memory
+		tail = pkg[:rest]
+	}
+	b.fileReader.resetCache()
+
+	physicalSize := headerSize + index*encryptedPackageSize
+	err := b.file.Truncate(physicalSize)
+	if err != nil {
+		return errors.Wrapf(err, "can't truncate a temp file '%s'", b.filename)
+	}
+	_, err = b.file.Seek(physicalSize, io.SeekStart)
+	if err != nil {
+		return errors.Wrapf(err, "can't seek a temp file '%s'", b.filename)
+	}
+
+	w, err := b.newEncryptWriter(uint32(index))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(tail)
+	if err != nil {
+		return errors.Wrap(err, "can't encrypt the last package")
+	}
+
+	if b.writingFinished {
+		err = w.Close()
+		if err != nil {
+			return errors.Wrap(err, "can't flush the encryption stream")
+		}
+	} else {
+		b.encryptWriter = w
+	}
+
+	b.fileSize = size
+
+	return b.truncatedFileHeader()
 }
 
-// sioDecryptReaderWrapper is a wrapper for sio.DecryptReader() function
-// that satisfy io.ReadCloser.
-// It reads from passed io.Reader and closes the original file
-type sioDecryptReaderWrapper struct {
-	r            io.Reader
-	originalFile *os.File
+// truncatedFileHeader updates the length in the file header after truncation. The header is updated
+// by Buffer.finishWriting() if writing isn't finished yet
+func (b *Buffer) truncatedFileHeader() error {
+	if !b.writingFinished {
+		return nil
+	}
+	return b.writeFileHeader()
 }
 
-func newSioDecryptReaderWrapper(r io.Reader, file *os.File) *sioDecryptReaderWrapper {
-	return &sioDecryptReaderWrapper{
-		r:            r,
-		originalFile: file,
+// Reset resets buffer and remove file if needed
+func (b *Buffer) Reset() {
+	if b.memoryShared {
+		// The memory is used by Readers, so it can't be reused
+		b.buff = bytes.Buffer{}
+		b.memoryShared = false
+	} else {
+		if b.secureWipe {
+			b.wipeMemory()
+		}
+		b.buff.Reset()
 	}
+	b.removeTempFile()
+
+	b.writingFinished = false
+	b.lastRead = false
+	b.size = 0
+	b.offset = 0
+	b.sizeHint = 0
+	b.resetHashes()
 }
 
-func (rw *sioDecryptReaderWrapper) Read(p []byte) (int, error) {
-	return rw.r.Read(p)
+// Close resets buffer and removes the temp file. If the file is used by Readers,
+// it is removed after all of them are closed
+func (b *Buffer) Close() error {
+	// The file mustn't be kept
+	reuse := b.reuseTempFile
+	b.reuseTempFile = false
+	defer func() {
+		b.reuseTempFile = reuse
+	}()
+
+	err := b.removeTempFile()
+	b.Reset()
+
+	if spareErr := b.releaseSpareFile(); err == nil {
+		err = spareErr
+	}
+	if unlockErr := b.unlockMemory(); err == nil {
+		err = unlockErr
+	}
+	return err
 }
 
-func (rw *sioDecryptReaderWrapper) Close() error {
-	return rw.originalFile.Close()
+// removeTempFile closes and removes the temp file if needed
+func (b *Buffer) removeTempFile() (err error) {
+	if b.encryptWriter != nil {
+		b.encryptWriter.Close()
+	}
+	if b.file != nil && !(b.reuseTempFile && b.recycleTempFile()) {
+		err = b.file.release()
+	}
+	if b.secureWipe {
+		b.fileReader.wipe()
+		if b.fileReader.encryptionKey != nil {
+			// The key is shared with fileReader. So, a new key must be generated for the next file
+			b.encryptionKeyWiped = true
+		}
+	}
+
+	b.encryptWriter = nil
+	b.file = nil
+	b.filename = ""
+	b.fileSize = 0
+	b.unsyncedSize = 0
+	b.fileReader = fileReader{
+		waitIO: b.waitRateLimiter,
+	}
+
+	return err
 }
@@ -3,6 +3,7 @@ package buffer
 import (
 	"bytes"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"testing"
@@ -301,6 +302,72 @@ func TestBuffer_Next(t *testing.T) {
 	}
 }
 
+func TestBuffer_Peek(t *testing.T) {
+	tests := []struct {
+		desc    string
+		maxSize int
+		encrypt bool
+		//
+		dataSize int
+		readSize int
+		peekSize int
+		//
+		peekedSize int
+		err        error
+	}{
+		{desc: "memory", maxSize: 100, dataSize: 50, peekSize: 20, peekedSize: 20},
+		{desc: "memory and file", maxSize: 10, dataSize: 50, peekSize: 20, peekedSize: 20},
+		{desc: "file", maxSize: 10, dataSize: 50, readSize: 15, peekSize: 20, peekedSize: 20},
+		{desc: "too many bytes", maxSize: 10, dataSize: 50, readSize: 15, peekSize: 100, peekedSize: 35, err: io.EOF},
+		{desc: "zero", maxSize: 10, dataSize: 50, peekSize: 0, peekedSize: 0},
+		{desc: "empty", maxSize: 10, dataSize: 0, peekSize: 1, peekedSize: 0, err: io.EOF},
+		{desc: "encrypted", maxSize: 100, encrypt: true, dataSize: 200 << 10, readSize: 10, peekSize: 100 << 10, peekedSize: 100 << 10},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Reset()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			read := make([]byte, tt.readSize)
+			_, err = io.ReadFull(b, read)
+			require.Nil(err)
+
+			peeked, err := b.Peek(tt.peekSize)
+			require.Equal(tt.err, err)
+			require.Equal(data[tt.readSize:tt.readSize+tt.peekedSize], peeked)
+
+			// Peek must not advance the reader
+			require.Equal(tt.dataSize-tt.readSize, b.Len())
+
+			res := readByChunks(require, b, 1000)
+			require.Equal(string(data[tt.readSize:]), string(res))
+		})
+	}
+
+	t.Run("negative count", func(t *testing.T) {
+		b := NewBufferString("hello")
+		defer b.Reset()
+
+		_, err := b.Peek(-1)
+		require.Equal(t, ErrNegativeCount, err)
+	})
+}
+
 func TestBuffer_ReadFrom(t *testing.T) {
 	tests := []struct {
 		before []byte
@@ -414,6 +481,65 @@ func TestBuffer_WriteSmth(t *testing.T) {
 	}
 }
 
+func TestBuffer_WriteAt(t *testing.T) {
+	tests := []struct {
+		desc    string
+		maxSize int
+		encrypt bool
+		//
+		dataSize int
+		patch    []byte
+		off      int64
+		//
+		err error
+	}{
+		{desc: "memory", maxSize: 100, dataSize: 50, patch: []byte("hello"), off: 10},
+		{desc: "memory and file", maxSize: 10, dataSize: 50, patch: []byte("hello"), off: 8},
+		{desc: "file", maxSize: 10, dataSize: 50, patch: []byte("hello"), off: 45},
+		{desc: "empty patch", maxSize: 10, dataSize: 50, patch: []byte{}, off: 50},
+		{desc: "out of range", maxSize: 10, dataSize: 50, patch: []byte("hello"), off: 46, err: ErrWriteAtOutOfRange},
+		{desc: "negative offset", maxSize: 10, dataSize: 50, patch: []byte("hello"), off: -1, err: ErrWriteAtOutOfRange},
+		{desc: "encrypted, memory", maxSize: 10, encrypt: true, dataSize: 50, patch: []byte("hello"), off: 5},
+		{desc: "encrypted, file", maxSize: 10, encrypt: true, dataSize: 50, patch: []byte("hello"), off: 6, err: ErrWriteAtEncrypted},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Reset()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			n, err := b.WriteAt(tt.patch, tt.off)
+			require.Equal(tt.err, err)
+			if err == nil {
+				require.Equal(len(tt.patch), n)
+				copy(data[tt.off:], tt.patch)
+			}
+
+			require.Equal(tt.dataSize, b.Len())
+
+			res := readByChunks(require, b, 1000)
+			require.Equal(data, res)
+
+			_, err = b.WriteAt([]byte("1"), 0)
+			require.Equal(ErrBufferFinished, err)
+		})
+	}
+}
+
 func TestBuffer_WriteTo(t *testing.T) {
 	tests := []struct {
 		data []byte
@@ -519,6 +645,181 @@ func TestBuffer_ChangeTempDir(t *testing.T) {
 
 }
 
+func TestBuffer_Truncate(t *testing.T) {
+	tests := []struct {
+		desc    string
+		maxSize int
+		encrypt bool
+		//
+		dataSize int
+		readSize int
+		truncate int
+		// afterData is written after Truncate()
+		afterData []byte
+	}{
+		{desc: "memory", maxSize: 100, dataSize: 50, truncate: 20, afterData: []byte("hello")},
+		{desc: "memory, file is removed", maxSize: 100, dataSize: 500, truncate: 70, afterData: []byte("hello")},
+		{desc: "file", maxSize: 100, dataSize: 500, truncate: 300, afterData: []byte("hello")},
+		{desc: "file, after read", maxSize: 100, dataSize: 500, readSize: 200, truncate: 100},
+		{desc: "encrypted, memory", maxSize: 100, encrypt: true, dataSize: 500, truncate: 70, afterData: []byte("hello")},
+		{desc: "encrypted, single package", maxSize: 100, encrypt: true, dataSize: 500, truncate: 300, afterData: []byte("hello")},
+		{desc: "encrypted, package boundary", maxSize: 0, encrypt: true, dataSize: 200 << 10, truncate: 128 << 10, afterData: []byte("hello")},
+		{desc: "encrypted, middle of a package", maxSize: 10, encrypt: true, dataSize: 200 << 10, truncate: 100 << 10, afterData: []byte(generateRandomString(100 << 10))},
+		{desc: "encrypted, after read", maxSize: 10, encrypt: true, dataSize: 200 << 10, readSize: 70 << 10, truncate: 50 << 10},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Reset()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			read := make([]byte, tt.readSize)
+			_, err = io.ReadFull(b, read)
+			require.Nil(err)
+			require.Equal(data[:tt.readSize], read)
+
+			err = b.Truncate(tt.truncate)
+			require.Nil(err)
+			require.Equal(tt.truncate, b.Len())
+
+			expected := data[tt.readSize : tt.readSize+tt.truncate]
+			if tt.afterData != nil {
+				_, err = b.Write(tt.afterData)
+				require.Nil(err)
+
+				expected = append(append([]byte{}, expected...), tt.afterData...)
+			}
+
+			res := readByChunks(require, b, 1000)
+			require.Equal(expected, res)
+		})
+	}
+
+	t.Run("out of range", func(t *testing.T) {
+		require := require.New(t)
+
+		b := NewBufferString("hello")
+		defer b.Reset()
+
+		require.Equal(ErrTruncateOutOfRange, b.Truncate(-1))
+		require.Equal(ErrTruncateOutOfRange, b.Truncate(6))
+
+		require.Nil(b.Truncate(0))
+		require.Equal(0, b.Len())
+	})
+}
+
+func TestBuffer_SizeAndRemaining(t *testing.T) {
+	require := require.New(t)
+
+	b := NewBufferWithMaxMemorySize(5)
+	defer b.Close()
+
+	data := []byte("Hello, world!")
+	_, err := b.Write(data)
+	require.Nil(err)
+	require.Equal(int64(len(data)), b.Size())
+	require.Equal(int64(len(data)), b.Remaining())
+
+	_ = b.Next(7)
+	require.Equal(int64(len(data)), b.Size())
+	require.Equal(int64(len(data)-7), b.Remaining())
+	require.Equal(len(data)-7, b.Len())
+
+	require.Nil(b.Truncate(3))
+	require.Equal(int64(10), b.Size())
+	require.Equal(int64(3), b.Remaining())
+
+	require.Equal(ErrTruncateOutOfRange, b.TruncateRemaining(4))
+	require.Equal(ErrTruncateOutOfRange, b.TruncateRemaining(-1))
+	require.Nil(b.TruncateRemaining(2))
+	require.Equal(int64(9), b.Size())
+	require.Equal(int64(2), b.Remaining())
+
+	b.Reset()
+	require.Equal(int64(0), b.Size())
+	require.Equal(int64(0), b.Remaining())
+}
+
+func TestSaturateInt(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(0, saturateInt(0))
+	require.Equal(12345, saturateInt(12345))
+	require.Equal(maxInt, saturateInt(int64(maxInt)))
+	require.Equal(maxInt, saturateInt(math.MaxInt64))
+
+	// Len saturates instead of wrapping around
+	b := NewBuffer(nil)
+	b.size = math.MaxInt64
+	require.Equal(int64(math.MaxInt64), b.Remaining())
+	require.Equal(saturateInt(math.MaxInt64), b.Len())
+	require.True(b.Len() > 0)
+
+	r := &Reader{size: math.MaxInt64}
+	require.Equal(int64(math.MaxInt64), r.Remaining())
+	require.Equal(saturateInt(math.MaxInt64), r.Len())
+	require.True(r.Len() > 0)
+}
+
+func TestBuffer_UnreadByte(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte("Hello, world!")
+
+			b := NewBufferWithMaxMemorySize(5)
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Reset()
+
+			// Nothing was read
+			require.Equal(ErrUnreadByte, b.UnreadByte())
+
+			b.Write(data)
+
+			for i := 0; i < len(data); i++ {
+				c, err := b.ReadByte()
+				require.Nil(err)
+				require.Equal(data[i], c)
+
+				// Unread the byte and read it again
+				require.Nil(b.UnreadByte())
+				require.Equal(len(data)-i, b.Len())
+				require.Equal(ErrUnreadByte, b.UnreadByte())
+
+				c, err = b.ReadByte()
+				require.Nil(err)
+				require.Equal(data[i], c)
+			}
+
+			_, err := b.ReadByte()
+			require.Equal(io.EOF, err)
+			require.Equal(ErrUnreadByte, b.UnreadByte())
+		})
+	}
+}
+
 func TestBuffer_FuzzTest(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
 
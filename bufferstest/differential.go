@@ -0,0 +1,345 @@
+// Package bufferstest helps to test code that uses buffer.Buffer. It provides a differential test harness,
+// which compares buffer.Buffer with bytes.Buffer, and temp files that inject faults
+package bufferstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+// Options are used to configure Check
+type Options struct {
+	// Seed is a seed of the random generator. The current time is used by default
+	Seed int64
+	// Sequences is a number of operation sequences. Every sequence uses a new Buffer. Default is 50
+	Sequences int
+	// Operations is a number of operations in a sequence. Default is 100
+	Operations int
+	// MaxChunkSize is a max number of bytes written or read by a single operation. Default is 1024
+	MaxChunkSize int
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if opts.Sequences <= 0 {
+		opts.Sequences = 50
+	}
+	if opts.Operations <= 0 {
+		opts.Operations = 100
+	}
+	if opts.MaxChunkSize <= 0 {
+		opts.MaxChunkSize = 1024
+	}
+}
+
+// Check runs random sequences of operations against bytes.Buffer and Buffers created by newBuffer
+// and compares the results. It returns an error with the seed and the failed sequence if the results
+// differ. Every Buffer is closed after its sequence.
+//
+// Buffer can't be written after reading (it returns buffer.ErrBufferFinished), Check expects this behavior
+func Check(newBuffer func() (*buffer.Buffer, error), opts Options) error {
+	opts.setDefaults()
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	for i := 0; i < opts.Sequences; i++ {
+		b, err := newBuffer()
+		if err != nil {
+			return errors.Wrap(err, "can't create a buffer")
+		}
+
+		s := &sequence{
+			rnd:          rnd,
+			maxChunkSize: opts.MaxChunkSize,
+			got:          b,
+		}
+		err = s.run(opts.Operations)
+		if closeErr := b.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "can't close the buffer")
+		}
+		if err != nil {
+			return errors.Errorf("seed %d, sequence %d: %s\noperations:\n%s", opts.Seed, i, err, strings.Join(s.log, "\n"))
+		}
+	}
+
+	return nil
+}
+
+// sequence applies random operations to both buffers
+type sequence struct {
+	rnd          *rand.Rand
+	maxChunkSize int
+
+	want bytes.Buffer
+	got  *buffer.Buffer
+	// finished is true when writing of got is finished
+	finished bool
+
+	log []string
+}
+
+type operation struct {
+	name string
+	fn   func(s *sequence) error
+}
+
+var operations = []operation{
+	{"Write", (*sequence).write},
+	{"WriteString", (*sequence).writeString},
+	{"WriteByte", (*sequence).writeByte},
+	{"WriteRune", (*sequence).writeRune},
+	{"ReadFrom", (*sequence).readFrom},
+	{"Read", (*sequence).read},
+	{"ReadByte", (*sequence).readByte},
+	{"Next", (*sequence).next},
+	{"Peek", (*sequence).peek},
+	{"UnreadByte", (*sequence).unreadByte},
+	{"Truncate", (*sequence).truncate},
+	{"WriteTo", (*sequence).writeTo},
+	{"Reset", (*sequence).reset},
+}
+
+func (s *sequence) run(count int) error {
+	for i := 0; i < count; i++ {
+		op := operations[s.rnd.Intn(len(operations))]
+		s.logf("%s", op.name)
+
+		err := op.fn(s)
+		if err != nil {
+			return errors.Wrapf(err, "operation %d (%s)", i, op.name)
+		}
+		if want, got := s.want.Len(), s.got.Len(); want != got {
+			return errors.Errorf("operation %d (%s): Len() = %d, want %d", i, op.name, got, want)
+		}
+	}
+	return nil
+}
+
+func (s *sequence) logf(format string, args ...interface{}) {
+	s.log = append(s.log, fmt.Sprintf(format, args...))
+}
+
+func (s *sequence) randomData() []byte {
+	data := make([]byte, s.rnd.Intn(s.maxChunkSize+1))
+	s.rnd.Read(data)
+	s.logf("\tsize: %d", len(data))
+	return data
+}
+
+func (s *sequence) checkWrite(wantN int, gotN int, gotErr error) error {
+	if s.finished {
+		if errors.Cause(gotErr) != buffer.ErrBufferFinished {
+			return errors.Errorf("got error %v, want %v", gotErr, buffer.ErrBufferFinished)
+		}
+		return nil
+	}
+
+	if gotErr != nil {
+		return errors.Wrap(gotErr, "unexpected error")
+	}
+	if gotN != wantN {
+		return errors.Errorf("got n = %d, want %d", gotN, wantN)
+	}
+	return nil
+}
+
+func (s *sequence) write() error {
+	data := s.randomData()
+
+	n, err := s.got.Write(data)
+	if !s.finished {
+		s.want.Write(data)
+	}
+	return s.checkWrite(len(data), n, err)
+}
+
+func (s *sequence) writeString() error {
+	data := string(s.randomData())
+
+	n, err := s.got.WriteString(data)
+	if !s.finished {
+		s.want.WriteString(data)
+	}
+	return s.checkWrite(len(data), n, err)
+}
+
+func (s *sequence) writeByte() error {
+	c := byte(s.rnd.Intn(256))
+
+	err := s.got.WriteByte(c)
+	if !s.finished {
+		s.want.WriteByte(c)
+	}
+	return s.checkWrite(0, 0, err)
+}
+
+func (s *sequence) writeRune() error {
+	r := rune(s.rnd.Intn(utf8.MaxRune + 1))
+	s.logf("\trune: %U", r)
+
+	n, err := s.got.WriteRune(r)
+	wantN := utf8.RuneLen(r)
+	if wantN < 0 {
+		// Invalid runes are replaced
+		wantN = utf8.RuneLen(utf8.RuneError)
+	}
+	if !s.finished {
+		s.want.WriteRune(r)
+	}
+	return s.checkWrite(wantN, n, err)
+}
+
+func (s *sequence) readFrom() error {
+	data := s.randomData()
+
+	n, err := s.got.ReadFrom(bytes.NewReader(data))
+	if !s.finished {
+		s.want.Write(data)
+	}
+	return s.checkWrite(len(data), int(n), err)
+}
+
+func (s *sequence) read() error {
+	size := s.rnd.Intn(s.maxChunkSize + 1)
+	s.logf("\tsize: %d", size)
+
+	want := make([]byte, size)
+	wantN, wantErr := s.want.Read(want)
+
+	got := make([]byte, size)
+	gotN, gotErr := s.got.Read(got)
+	s.finished = true
+
+	if gotErr != wantErr {
+		return errors.Errorf("got error %v, want %v", gotErr, wantErr)
+	}
+	if gotN != wantN {
+		return errors.Errorf("got n = %d, want %d", gotN, wantN)
+	}
+	return compareData(want[:wantN], got[:gotN])
+}
+
+func (s *sequence) readByte() error {
+	want, wantErr := s.want.ReadByte()
+	got, gotErr := s.got.ReadByte()
+	s.finished = true
+
+	if gotErr != wantErr {
+		return errors.Errorf("got error %v, want %v", gotErr, wantErr)
+	}
+	if gotErr == nil && got != want {
+		return errors.Errorf("got byte %d, want %d", got, want)
+	}
+	return nil
+}
+
+func (s *sequence) next() error {
+	n := s.rnd.Intn(s.maxChunkSize + 1)
+	s.logf("\tn: %d", n)
+
+	want := s.want.Next(n)
+	got := s.got.Next(n)
+	s.finished = true
+
+	return compareData(want, got)
+}
+
+func (s *sequence) peek() error {
+	n := s.rnd.Intn(s.maxChunkSize + 1)
+	s.logf("\tn: %d", n)
+
+	// bytes.Buffer doesn't have Peek method
+	var (
+		want    = s.want.Bytes()
+		wantErr error
+	)
+	if n > len(want) {
+		wantErr = io.EOF
+	} else {
+		want = want[:n]
+	}
+
+	got, gotErr := s.got.Peek(n)
+	s.finished = true
+
+	if gotErr != wantErr {
+		return errors.Errorf("got error %v, want %v", gotErr, wantErr)
+	}
+	return compareData(want, got)
+}
+
+func (s *sequence) unreadByte() error {
+	wantErr := s.want.UnreadByte()
+	gotErr := s.got.UnreadByte()
+
+	if (wantErr == nil) != (gotErr == nil) {
+		return errors.Errorf("got error %v, want %v", gotErr, wantErr)
+	}
+	return nil
+}
+
+func (s *sequence) truncate() error {
+	n := s.rnd.Intn(s.want.Len() + 1)
+	s.logf("\tn: %d", n)
+
+	s.want.Truncate(n)
+	err := s.got.Truncate(n)
+	if n == 0 {
+		// Truncate(0) resets the Buffer
+		s.finished = false
+	}
+	if err != nil {
+		return errors.Wrap(err, "unexpected error")
+	}
+	return nil
+}
+
+func (s *sequence) writeTo() error {
+	var want, got bytes.Buffer
+
+	wantN, wantErr := s.want.WriteTo(&want)
+	gotN, gotErr := s.got.WriteTo(&got)
+	s.finished = true
+
+	if gotErr != wantErr {
+		return errors.Errorf("got error %v, want %v", gotErr, wantErr)
+	}
+	if gotN != wantN {
+		return errors.Errorf("got n = %d, want %d", gotN, wantN)
+	}
+	return compareData(want.Bytes(), got.Bytes())
+}
+
+func (s *sequence) reset() error {
+	s.want.Reset()
+	s.got.Reset()
+	s.finished = false
+
+	return nil
+}
+
+func compareData(want, got []byte) error {
+	if !bytes.Equal(want, got) {
+		return errors.Errorf("got %d bytes %q, want %d bytes %q", len(got), truncate(got), len(want), truncate(want))
+	}
+	return nil
+}
+
+// truncate shortens data for error messages
+func truncate(data []byte) []byte {
+	const maxSize = 32
+	if len(data) > maxSize {
+		return data[:maxSize]
+	}
+	return data
+}
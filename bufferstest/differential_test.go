@@ -0,0 +1,111 @@
+package bufferstest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		newBuffer func() (*buffer.Buffer, error)
+	}{
+		{
+			name: "memory",
+			newBuffer: func() (*buffer.Buffer, error) {
+				return buffer.NewBufferWithMaxMemorySize(1 << 20), nil
+			},
+		},
+		{
+			name: "memory and disk",
+			newBuffer: func() (*buffer.Buffer, error) {
+				return buffer.NewBufferWithMaxMemorySize(2 << 10), nil
+			},
+		},
+		{
+			name: "disk",
+			newBuffer: func() (*buffer.Buffer, error) {
+				return buffer.NewBufferWithMaxMemorySize(0), nil
+			},
+		},
+		{
+			name: "encryption",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				return b, b.EnableEncryption()
+			},
+		},
+		{
+			name: "secure wipe",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				b.EnableSecureWipe(true)
+				return b, nil
+			},
+		},
+		{
+			name: "secure wipe and encryption",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				b.EnableSecureWipe(false)
+				return b, b.EnableEncryption()
+			},
+		},
+		{
+			name: "temp file reuse",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				b.EnableTempFileReuse()
+				return b, b.EnableEncryption()
+			},
+		},
+		{
+			name: "size hint",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				return b, b.SizeHint(4 << 10)
+			},
+		},
+		{
+			name: "file header",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				return b, b.EnableFileHeader()
+			},
+		},
+		{
+			name: "file header and encryption",
+			newBuffer: func() (*buffer.Buffer, error) {
+				b := buffer.NewBufferWithMaxMemorySize(2 << 10)
+				err := b.EnableFileHeader()
+				if err != nil {
+					return nil, err
+				}
+				return b, b.EnableEncryption()
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Check(tt.newBuffer, Options{})
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestCheck_Large(t *testing.T) {
+	t.Parallel()
+
+	// Chunks are larger than encrypted packages
+	err := Check(func() (*buffer.Buffer, error) {
+		b := buffer.NewBufferWithMaxMemorySize(100 << 10)
+		return b, b.EnableEncryption()
+	}, Options{Sequences: 10, Operations: 50, MaxChunkSize: 200 << 10})
+	require.Nil(t, err)
+}
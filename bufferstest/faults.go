@@ -0,0 +1,141 @@
+package bufferstest
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+// Fault is a fault injected into temp files by FaultInjector
+type Fault int
+
+const (
+	// NoFault doesn't inject faults
+	NoFault Fault = iota
+	// NoSpace makes writes fail with ENOSPC
+	NoSpace
+	// ShortWrite makes writes write fewer bytes than requested and return io.ErrShortWrite
+	ShortWrite
+	// ReadError makes reads fail with EIO
+	ReadError
+	// Deleted removes the file right after the creation. The file can still be used through its descriptor
+	// on Unix, but Buffer can't remove it
+	Deleted
+)
+
+// FaultInjector creates temp files that inject a fault. Usage:
+//
+//	fi := &bufferstest.FaultInjector{Fault: bufferstest.NoSpace, After: 1 << 20}
+//	b.SetTempFileCreator(fi.Create)
+type FaultInjector struct {
+	Fault Fault
+	// After is a number of bytes that are written (or read) successfully before the fault
+	After int64
+}
+
+// Create creates a temp file in dir. It can be passed to buffer.Buffer.SetTempFileCreator()
+func (fi *FaultInjector) Create(dir string) (buffer.TempFile, error) {
+	file, err := ioutil.TempFile(dir, "go-disk-buffer-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Fault == Deleted {
+		err = os.Remove(file.Name())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &faultyFile{
+		File:  file,
+		fault: fi.Fault,
+		after: fi.After,
+	}, nil
+}
+
+// faultyFile is a temp file that injects a fault. It can be used concurrently by Buffer Readers
+type faultyFile struct {
+	*os.File
+
+	fault Fault
+	after int64
+
+	mu      sync.Mutex
+	written int64
+	read    int64
+}
+
+func (f *faultyFile) Write(data []byte) (int, error) {
+	return f.write(data, f.File.Write)
+}
+
+func (f *faultyFile) WriteAt(data []byte, off int64) (int, error) {
+	return f.write(data, func(data []byte) (int, error) {
+		return f.File.WriteAt(data, off)
+	})
+}
+
+func (f *faultyFile) Read(data []byte) (int, error) {
+	return f.readData(data, f.File.Read)
+}
+
+func (f *faultyFile) ReadAt(data []byte, off int64) (int, error) {
+	return f.readData(data, func(data []byte) (int, error) {
+		return f.File.ReadAt(data, off)
+	})
+}
+
+func (f *faultyFile) write(data []byte, write func([]byte) (int, error)) (int, error) {
+	if f.fault != NoSpace && f.fault != ShortWrite {
+		return write(data)
+	}
+
+	allowed := f.allowed(&f.written, len(data))
+
+	n, err := write(data[:allowed])
+	if err != nil || allowed == len(data) {
+		return n, err
+	}
+
+	if f.fault == NoSpace {
+		return n, &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	return n, io.ErrShortWrite
+}
+
+func (f *faultyFile) readData(data []byte, read func([]byte) (int, error)) (int, error) {
+	if f.fault != ReadError {
+		return read(data)
+	}
+
+	allowed := f.allowed(&f.read, len(data))
+
+	n, err := read(data[:allowed])
+	if err != nil || allowed == len(data) {
+		return n, err
+	}
+	return n, &os.PathError{Op: "read", Path: f.Name(), Err: syscall.EIO}
+}
+
+// allowed returns a number of bytes that can be processed before the fault and updates the counter
+func (f *faultyFile) allowed(counter *int64, size int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	allowed := f.after - *counter
+	if allowed < 0 {
+		allowed = 0
+	}
+	if allowed > int64(size) {
+		allowed = int64(size)
+	}
+	*counter += allowed
+
+	return int(allowed)
+}
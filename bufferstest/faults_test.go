@@ -0,0 +1,120 @@
+package bufferstest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+func TestFaultInjector(t *testing.T) {
+	data := make([]byte, 100<<10)
+
+	newBuffer := func(t *testing.T, fi *FaultInjector, encrypt bool) *buffer.Buffer {
+		b := buffer.NewBufferWithMaxMemorySize(1 << 10)
+		b.SetTempFileCreator(fi.Create)
+		if encrypt {
+			require.Nil(t, b.EnableEncryption())
+		}
+		return b
+	}
+
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+		prefix := fmt.Sprintf("encrypt: %t, ", encrypt)
+
+		t.Run(prefix+"no fault", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := newBuffer(t, &FaultInjector{Fault: NoFault}, encrypt)
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, res)
+			require.Nil(b.Close())
+		})
+
+		t.Run(prefix+"no space", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := newBuffer(t, &FaultInjector{Fault: NoSpace, After: 10 << 10}, encrypt)
+			defer b.Close()
+
+			n, err := b.Write(data)
+			require.NotNil(err)
+			require.True(n < len(data))
+
+			pathErr, ok := errors.Cause(err).(*os.PathError)
+			require.True(ok, "unexpected error: %v", err)
+			require.Equal(syscall.ENOSPC, pathErr.Err)
+		})
+
+		t.Run(prefix+"short write", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := newBuffer(t, &FaultInjector{Fault: ShortWrite, After: 10 << 10}, encrypt)
+			defer b.Close()
+
+			n, err := b.Write(data)
+			require.Equal(io.ErrShortWrite, errors.Cause(err))
+			require.True(n < len(data))
+		})
+
+		t.Run(prefix+"read error", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := newBuffer(t, &FaultInjector{Fault: ReadError, After: 10 << 10}, encrypt)
+			defer b.Close()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			_, err = ioutil.ReadAll(b)
+			pathErr, ok := errors.Cause(err).(*os.PathError)
+			require.True(ok, "unexpected error: %v", err)
+			require.Equal(syscall.EIO, pathErr.Err)
+		})
+
+		t.Run(prefix+"deleted", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := newBuffer(t, &FaultInjector{Fault: Deleted}, encrypt)
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, res)
+
+			// The file was removed by ReadAll
+			require.Nil(b.Close())
+
+			// The file is removed by Close
+			b = newBuffer(t, &FaultInjector{Fault: Deleted}, encrypt)
+			_, err = b.Write(data)
+			require.Nil(err)
+			require.True(os.IsNotExist(errors.Cause(b.Close())))
+		})
+	}
+}
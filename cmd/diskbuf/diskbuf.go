@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+const (
+	// ioBufferSize is a size of buffers for the input and the output
+	ioBufferSize = 64 << 10 // 64 KB
+	// maxQueuedChunks is a max number of chunks that wait for the output in pipe mode
+	maxQueuedChunks = 1024
+)
+
+// config is used to configure run
+type config struct {
+	// maxMemory is a max number of bytes stored in memory. In pipe mode it is shared by all chunks
+	maxMemory int64
+	// dirs are directories for temp files. os.TempDir() is used if it is empty
+	dirs []string
+	// encrypt enables encryption of temp files
+	encrypt bool
+	// compress enables compression of buffered data
+	compress bool
+	// pipe enables pipe mode: the output is written while the input is read
+	pipe bool
+	// chunkSize is a max number of input bytes in a chunk in pipe mode
+	chunkSize int64
+	// output is a path of the output file. Stdout is used if it is empty
+	output string
+}
+
+// stats are printed after the run
+type stats struct {
+	// read is a number of bytes read from the input
+	read int64
+	// buffered is a number of bytes stored in buffers. It is less than read if compression is enabled
+	buffered int64
+	// memory and disk are numbers of buffered bytes stored in memory and on a disk
+	memory int64
+	disk   int64
+	// written is a number of bytes written into the output
+	written int64
+	// chunks is a number of chunks in pipe mode
+	chunks int
+
+	elapsed time.Duration
+}
+
+func (s stats) String() string {
+	res := fmt.Sprintf("read %s, buffered %s (%s in memory, %s on disk)",
+		formatSize(s.read), formatSize(s.buffered), formatSize(s.memory), formatSize(s.disk),
+	)
+	if s.chunks > 0 {
+		res += fmt.Sprintf(" in %d chunks", s.chunks)
+	}
+
+	var speed int64
+	if seconds := s.elapsed.Seconds(); seconds > 0 {
+		speed = int64(float64(s.written) / seconds)
+	}
+	res += fmt.Sprintf(", wrote %s in %s (%s/s)", formatSize(s.written), s.elapsed.Round(time.Millisecond), formatSize(speed))
+
+	return res
+}
+
+// run buffers data of in and writes it into stdout or into the output file
+func run(ctx context.Context, cfg config, in io.Reader, stdout io.Writer) (stats, error) {
+	start := time.Now()
+
+	d := &diskbuf{
+		cfg:    cfg,
+		budget: &memoryBudget{limit: cfg.maxMemory},
+	}
+	if len(cfg.dirs) != 0 {
+		dirs, err := buffer.NewSpillDirs(cfg.dirs, buffer.SpillDirsOptions{})
+		if err != nil {
+			return d.stats, err
+		}
+		d.dirs = dirs
+	}
+
+	input := &countingReader{r: bufio.NewReaderSize(in, ioBufferSize)}
+
+	var err error
+	if cfg.pipe {
+		err = d.runPipe(ctx, input, stdout)
+	} else {
+		err = d.runSponge(ctx, input, stdout)
+	}
+
+	d.stats.read = input.count()
+	d.stats.elapsed = time.Since(start)
+
+	return d.stats, err
+}
+
+type diskbuf struct {
+	cfg    config
+	dirs   *buffer.SpillDirs
+	budget *memoryBudget
+
+	stats stats
+}
+
+// runSponge reads all data of in and only then writes it into the output
+func (d *diskbuf) runSponge(ctx context.Context, in io.Reader, stdout io.Writer) error {
+	// A plain output file is committed with a rename. Anonymous temp files can't be renamed
+	commit := d.cfg.output != "" && !d.cfg.compress
+
+	b, err := d.newBuffer(!commit)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	_, err = d.fill(ctx, b, in)
+	if err != nil {
+		return err
+	}
+
+	if commit {
+		written := b.Remaining()
+		err := commitFile(b, d.cfg.output)
+		if err != nil {
+			return err
+		}
+		d.stats.written = written
+		return nil
+	}
+
+	if d.cfg.output != "" {
+		return writeFile(d.cfg.output, func(w io.Writer) error {
+			return d.drain(w, b)
+		})
+	}
+
+	w := bufio.NewWriterSize(stdout, ioBufferSize)
+	err = d.drain(w, b)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// chunk is a part of the input in pipe mode
+type chunk struct {
+	b *buffer.Buffer
+	// memory is a number of bytes of the chunk stored in memory
+	memory int64
+}
+
+// runPipe splits the input into chunks. Chunks are written into the output while the input is read,
+// so the output doesn't block the input
+func (d *diskbuf) runPipe(ctx context.Context, in *countingReader, stdout io.Writer) error {
+	out := stdout
+	if d.cfg.output != "" {
+		file, err := os.OpenFile(d.cfg.output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return errors.Wrap(err, "can't open the output file")
+		}
+		defer file.Close()
+
+		out = file
+	}
+	w := bufio.NewWriterSize(out, ioBufferSize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan chunk, maxQueuedChunks)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- d.readChunks(ctx, in, chunks)
+	}()
+
+	var writeErr error
+	for c := range chunks {
+		if writeErr == nil {
+			writeErr = d.drain(w, c.b)
+			if writeErr == nil && len(chunks) == 0 {
+				// Don't keep data in the buffer while waiting for the next chunk
+				writeErr = w.Flush()
+			}
+			if writeErr != nil {
+				// Stop reading
+				cancel()
+			}
+		}
+
+		c.b.Close()
+		d.budget.release(c.memory)
+	}
+
+	err := <-readErr
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readChunks reads chunks of in and sends them into chunks until EOF. It closes chunks
+func (d *diskbuf) readChunks(ctx context.Context, in *countingReader, chunks chan<- chunk) error {
+	defer close(chunks)
+
+	for {
+		b, err := d.newBuffer(true)
+		if err != nil {
+			return err
+		}
+
+		read := in.count()
+		memory, err := d.fill(ctx, b, io.LimitReader(in, d.cfg.chunkSize))
+		if err == nil && in.count() == read {
+			// EOF
+			b.Close()
+			return nil
+		}
+		if err != nil {
+			b.Close()
+			return err
+		}
+
+		d.budget.use(memory)
+		d.stats.chunks++
+
+		select {
+		case chunks <- chunk{b: b, memory: memory}:
+		case <-ctx.Done():
+			b.Close()
+			d.budget.release(memory)
+			return ctx.Err()
+		}
+	}
+}
+
+// newBuffer creates a Buffer that respects the memory budget
+func (d *diskbuf) newBuffer(anonymous bool) (*buffer.Buffer, error) {
+	b := buffer.NewBufferWithMaxMemorySize(0)
+	b.SetSpillPolicy(d.budget)
+	if d.dirs != nil {
+		b.SetSpillDirs(d.dirs)
+	}
+	if anonymous && runtime.GOOS != "windows" {
+		// Temp files are freed even if the process is killed
+		err := b.EnableAnonymousTempFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.cfg.encrypt {
+		err := b.EnableEncryption()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// fill reads data from r into b. The data is compressed if needed. It returns a number of bytes stored in memory
+func (d *diskbuf) fill(ctx context.Context, b *buffer.Buffer, r io.Reader) (memory int64, err error) {
+	if d.cfg.compress {
+		compressed := compress(r)
+		defer compressed.Close()
+
+		r = compressed
+	}
+
+	var progress buffer.Progress
+	_, err = b.ReadFromContext(ctx, r, buffer.WithProgress(func(p buffer.Progress) {
+		progress = p
+	}))
+
+	d.stats.buffered += progress.Transferred
+	d.stats.memory += progress.Memory
+	d.stats.disk += progress.Disk
+
+	if err != nil {
+		return progress.Memory, errors.Wrap(err, "can't read the input")
+	}
+	return progress.Memory, nil
+}
+
+// drain writes data of b into w. The data is decompressed if needed
+func (d *diskbuf) drain(w io.Writer, b *buffer.Buffer) error {
+	var r io.Reader = b
+	if d.cfg.compress {
+		decompressed := flate.NewReader(b)
+		defer decompressed.Close()
+
+		r = decompressed
+	}
+
+	n, err := io.Copy(w, r)
+	d.stats.written += n
+	if err != nil {
+		return errors.Wrap(err, "can't write the output")
+	}
+	return nil
+}
+
+// compress returns a reader of compressed data of r
+func compress(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		// flate.NewWriter returns an error only for an invalid level
+		w, _ := flate.NewWriter(pw, flate.BestSpeed)
+
+		_, err := io.Copy(w, r)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// commitFile saves data of b into a file with passed path. The file is replaced atomically
+func commitFile(b *buffer.Buffer, path string) error {
+	return b.CommitTo(path, outputMode(path))
+}
+
+// writeFile writes a file with passed path with write. The data is written into a temp file in the same
+// directory, which is renamed after the successful write. So, the file is replaced atomically
+func writeFile(path string, write func(w io.Writer) error) (err error) {
+	mode := outputMode(path)
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".diskbuf-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "can't create a temp file for the output")
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	w := bufio.NewWriterSize(file, ioBufferSize)
+	err = write(w)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "can't write the output")
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "can't sync the output")
+	}
+	err = file.Chmod(mode)
+	if err != nil {
+		return errors.Wrap(err, "can't change mode of the output")
+	}
+	err = file.Close()
+	if err != nil {
+		return errors.Wrap(err, "can't close the output")
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "can't rename the output")
+	}
+	return nil
+}
+
+// outputMode returns the mode of the existing output file. New files get 0644
+func outputMode(path string) os.FileMode {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0644
+	}
+	return info.Mode().Perm()
+}
+
+// memoryBudget is a buffer.SpillPolicy shared by all Buffers. It limits the total number of bytes
+// stored in memory by chunks that wait for the output
+type memoryBudget struct {
+	limit int64
+	used  int64
+}
+
+func (m *memoryBudget) MaxMemorySize() int {
+	rest := m.limit - atomic.LoadInt64(&m.used)
+	if rest < 0 {
+		return 0
+	}
+	if rest > int64(maxInt) {
+		return maxInt
+	}
+	return int(rest)
+}
+
+func (m *memoryBudget) use(n int64) {
+	atomic.AddInt64(&m.used, n)
+}
+
+func (m *memoryBudget) release(n int64) {
+	atomic.AddInt64(&m.used, -n)
+}
+
+// maxInt is the max value of int
+const maxInt = int(^uint(0) >> 1)
+
+// countingReader counts read bytes. The counter can be read concurrently
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(data []byte) (int, error) {
+	n, err := r.r.Read(data)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	data := make([]byte, 3<<20)
+	rand.Read(data[:1<<20])
+	// Compressible data
+	for i := 1 << 20; i < len(data); i++ {
+		data[i] = byte(i % 7)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config
+	}{
+		{name: "memory", cfg: config{maxMemory: 10 << 20}},
+		{name: "disk", cfg: config{maxMemory: 1 << 10}},
+		{name: "encrypted", cfg: config{maxMemory: 1 << 10, encrypt: true}},
+		{name: "compressed", cfg: config{maxMemory: 1 << 10, compress: true}},
+		{name: "pipe", cfg: config{maxMemory: 1 << 20, pipe: true, chunkSize: 300 << 10}},
+		{name: "pipe, encrypted and compressed", cfg: config{maxMemory: 1 << 10, pipe: true, chunkSize: 300 << 10, encrypt: true, compress: true}},
+		{name: "pipe, single chunk", cfg: config{maxMemory: 1 << 20, pipe: true, chunkSize: 10 << 20}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			dir, err := ioutil.TempDir("", "diskbuf-test-")
+			require.Nil(err)
+			defer os.RemoveAll(dir)
+
+			cfg := tt.cfg
+			cfg.dirs = []string{dir}
+
+			var out bytes.Buffer
+			st, err := run(context.Background(), cfg, bytes.NewReader(data), &out)
+			require.Nil(err)
+			require.Equal(data, out.Bytes())
+
+			require.Equal(int64(len(data)), st.read)
+			require.Equal(int64(len(data)), st.written)
+			require.Equal(st.buffered, st.memory+st.disk)
+			if !cfg.pipe {
+				require.True(st.memory <= cfg.maxMemory)
+			}
+			if cfg.compress {
+				require.True(st.buffered < st.read, "data must be compressed")
+			}
+			if cfg.pipe {
+				require.Equal(int((int64(len(data))+cfg.chunkSize-1)/cfg.chunkSize), st.chunks)
+			}
+
+			// Temp files must be removed
+			files, err := ioutil.ReadDir(dir)
+			require.Nil(err)
+			require.Empty(files)
+		})
+	}
+
+	t.Run("empty input", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		for _, cfg := range []config{
+			{maxMemory: 1 << 10},
+			{maxMemory: 1 << 10, compress: true},
+			{maxMemory: 1 << 10, pipe: true, chunkSize: 1 << 10},
+		} {
+			var out bytes.Buffer
+			st, err := run(context.Background(), cfg, bytes.NewReader(nil), &out)
+			require.Nil(err)
+			require.Equal(0, out.Len())
+			require.Equal(int64(0), st.written)
+			require.Equal(0, st.chunks)
+		}
+	})
+
+	t.Run("output file", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "diskbuf-test-")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		for _, cfg := range []config{
+			{maxMemory: 1 << 10},
+			{maxMemory: 1 << 10, encrypt: true},
+			{maxMemory: 1 << 10, compress: true},
+			{maxMemory: 1 << 10, pipe: true, chunkSize: 1 << 20},
+		} {
+			require := require.New(t)
+
+			path := filepath.Join(dir, "output")
+			require.Nil(ioutil.WriteFile(path, []byte("old content"), 0600))
+
+			cfg.dirs = []string{dir}
+			cfg.output = path
+
+			var out bytes.Buffer
+			st, err := run(context.Background(), cfg, bytes.NewReader(data), &out)
+			require.Nil(err)
+			require.Equal(0, out.Len())
+			require.Equal(int64(len(data)), st.written)
+
+			res, err := ioutil.ReadFile(path)
+			require.Nil(err)
+			require.Equal(data, res)
+
+			// The mode must be kept
+			info, err := os.Stat(path)
+			require.Nil(err)
+			require.Equal(os.FileMode(0600), info.Mode().Perm())
+
+			// Only the output file must be left
+			files, err := ioutil.ReadDir(dir)
+			require.Nil(err)
+			require.Len(files, 1)
+
+			require.Nil(os.Remove(path))
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		for _, cfg := range []config{
+			{maxMemory: 1 << 10},
+			{maxMemory: 1 << 10, pipe: true, chunkSize: 1 << 20},
+		} {
+			var out bytes.Buffer
+			_, err := run(ctx, cfg, bytes.NewReader(data), &out)
+			require.NotNil(err)
+			require.Equal(0, out.Len())
+		}
+	})
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "0", want: 0},
+		{s: "512", want: 512},
+		{s: "64K", want: 64 << 10},
+		{s: "64k", want: 64 << 10},
+		{s: "2M", want: 2 << 20},
+		{s: "2MB", want: 2 << 20},
+		{s: "2MiB", want: 2 << 20},
+		{s: "3G", want: 3 << 30},
+		{s: "1T", want: 1 << 40},
+		{s: "", wantErr: true},
+		{s: "-1M", wantErr: true},
+		{s: "1.5M", wantErr: true},
+		{s: "10X", wantErr: true},
+		{s: "9999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			n, err := parseSize(tt.s)
+			if tt.wantErr {
+				require.NotNil(err)
+				return
+			}
+			require.Nil(err)
+			require.Equal(tt.want, n)
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	require := require.New(t)
+
+	cfg, quiet, err := parseArgs([]string{"-m", "16M", "-d", "/tmp", "-d", "/var/tmp", "-e", "-z", "-p", "-s", "1M", "-o", "out.txt", "-q"}, ioutil.Discard)
+	require.Nil(err)
+	require.True(quiet)
+	require.Equal(config{
+		maxMemory: 16 << 20,
+		dirs:      []string{"/tmp", "/var/tmp"},
+		encrypt:   true,
+		compress:  true,
+		pipe:      true,
+		chunkSize: 1 << 20,
+		output:    "out.txt",
+	}, cfg)
+
+	_, _, err = parseArgs([]string{"-m", "abc"}, ioutil.Discard)
+	require.NotNil(err)
+
+	_, _, err = parseArgs([]string{"-s", "0"}, ioutil.Discard)
+	require.NotNil(err)
+
+	_, _, err = parseArgs([]string{"file.txt"}, ioutil.Discard)
+	require.NotNil(err)
+}
@@ -0,0 +1,113 @@
+// Command diskbuf buffers stdin and writes it to stdout or into a file. By default it works like sponge:
+// all input is read before the output is written, so the downstream command doesn't start until
+// the upstream one has finished. The input is stored in memory up to a limit and spilled into temp files.
+//
+// In pipe mode (-p) diskbuf works like mbuffer: the input is split into chunks, which are written
+// to the output while the rest of the input is read. A slow consumer doesn't block the producer:
+// chunks wait in memory and on a disk.
+//
+// Usage:
+//
+//	diskbuf [flags]
+//
+// Examples:
+//
+//	sort huge.txt | diskbuf -m 256M -d /mnt/ssd -e | gzip > sorted.gz
+//	grep -v foo data.txt | diskbuf -o data.txt
+//	producer | diskbuf -p -s 16M -z | slow-consumer
+//
+// Statistics are printed to stderr at the end (use -q to disable them)
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+const (
+	defaultChunkSize = 64 << 20 // 64 MB
+)
+
+func main() {
+	cfg, quiet, err := parseArgs(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diskbuf: %s\n", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Stop between chunks and remove temp files. The next signal kills the process
+		signal.Stop(signals)
+		cancel()
+	}()
+
+	st, err := run(ctx, cfg, os.Stdin, os.Stdout)
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "diskbuf: %s\n", st)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diskbuf: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses command-line flags
+func parseArgs(args []string, output io.Writer) (cfg config, quiet bool, err error) {
+	var (
+		maxMemory = sizeFlag(buffer.DefaultMaxMemorySize)
+		chunkSize = sizeFlag(defaultChunkSize)
+		dirs      dirsFlag
+	)
+
+	flags := flag.NewFlagSet("diskbuf", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprint(output, "Usage: diskbuf [flags]\n\nBuffers stdin in memory and on a disk and writes it to stdout or into a file.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Var(&maxMemory, "m", "max `size` of data in memory (K, M, G and T suffixes are supported)")
+	flags.Var(&dirs, "d", "`directory` for temp files, can be repeated (default is os.TempDir())")
+	flags.BoolVar(&cfg.encrypt, "e", false, "encrypt temp files")
+	flags.BoolVar(&cfg.compress, "z", false, "compress buffered data")
+	flags.BoolVar(&cfg.pipe, "p", false, "pipe mode: write the output while the input is read")
+	flags.Var(&chunkSize, "s", "max `size` of a chunk in pipe mode")
+	flags.StringVar(&cfg.output, "o", "", "output `file` (default is stdout). It is replaced atomically unless pipe mode is used")
+	flags.BoolVar(&quiet, "q", false, "don't print statistics")
+
+	err = flags.Parse(args)
+	if err != nil {
+		return cfg, false, err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return cfg, false, errors.Errorf("unexpected arguments: %q", flags.Args())
+	}
+	if chunkSize <= 0 {
+		return cfg, false, errors.New("chunk size must be greater than zero")
+	}
+
+	cfg.maxMemory = int64(maxMemory)
+	cfg.chunkSize = int64(chunkSize)
+	cfg.dirs = dirs
+
+	return cfg, quiet, nil
+}
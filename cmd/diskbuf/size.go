@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sizeSuffixes = []struct {
+	suffix string
+	size   int64
+}{
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+}
+
+// parseSize parses a number of bytes with an optional suffix: K, M, G or T (powers of 1024).
+// For example, "512", "64K" or "2G"
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	for _, suffix := range sizeSuffixes {
+		if strings.HasSuffix(s, suffix.suffix) {
+			s = strings.TrimSuffix(s, suffix.suffix)
+			multiplier = suffix.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size '%s'", s)
+	}
+	if n < 0 {
+		return 0, errors.Errorf("size can't be negative")
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, errors.Errorf("size is too large")
+	}
+	return n * multiplier, nil
+}
+
+// formatSize formats a number of bytes with a binary suffix. For example, "1.5 MiB"
+func formatSize(n int64) string {
+	for i := len(sizeSuffixes) - 1; i >= 0; i-- {
+		if n >= sizeSuffixes[i].size {
+			return fmt.Sprintf("%.1f %siB", float64(n)/float64(sizeSuffixes[i].size), sizeSuffixes[i].suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// sizeFlag is a flag.Value for sizes parsed by parseSize
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return formatSize(int64(*f))
+}
+
+func (f *sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(n)
+	return nil
+}
+
+// dirsFlag is a flag.Value for a list of directories. Every usage of the flag adds a directory
+type dirsFlag []string
+
+func (f *dirsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *dirsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,187 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// File finishes writing and returns a plain (unencrypted) file which contains only unread data.
+// If needed, the data is moved from memory and from an encrypted file into a new temp file.
+// The returned file is positioned at the beginning.
+//
+// The existing temp file is returned as is only if it is plain and contains all unread data: nothing is stored
+// in memory and nothing has been read. Otherwise, all unread data is copied into a new temp file, even if only
+// a small prefix is stored in memory or only a few bytes have been read. So, use NewBufferWithMaxMemorySize(0)
+// and don't read the Buffer before File() to avoid the copy.
+//
+// The file is owned by the Buffer: it must not be closed and it is removed by Buffer.Close(),
+// Buffer.Reset() or after reading all data from the Buffer.
+//
+// File returns ErrRingModeUnsupported in ring mode
+func (b *Buffer) File() (*os.File, error) {
+	file, err := b.plainFile()
+	if err != nil {
+		return nil, err
+	}
+
+	osFile, ok := file.(*os.File)
+	if !ok {
+		return nil, errors.New("temp file isn't *os.File: it was created by a custom TempFileCreator")
+	}
+	return osFile, nil
+}
+
+// plainFile moves unread data into a plain temp file and returns this file positioned at the beginning
+func (b *Buffer) plainFile() (TempFile, error) {
+	err := b.moveToPlainFile()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't seek a temp file '%s'", b.filename)
+	}
+
+	return b.file.TempFile, nil
+}
+
+// CommitTo finishes writing and saves unread data into a file with passed path. At first, the data
+// is moved into a plain temp file (see Buffer.File(), the data can be copied). Then the temp file is renamed.
+// If the directory for temp files and path are on different filesystems or the temp file is anonymous,
+// the data is copied into a temp file in the directory of path and this file is renamed. So, the file
+// with passed path is replaced atomically.
+//
+// The file gets perm mode before the rename. Unlike os.OpenFile, umask isn't applied to perm.
+//
+// The Buffer is reset after the successful commit
+func (b *Buffer) CommitTo(path string, perm os.FileMode) error {
+	file, err := b.plainFile()
+	if err != nil {
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "can't sync a temp file '%s'", b.filename)
+	}
+
+	if b.file.anonymous {
+		// Anonymous file can't be renamed
+		err = copyToFile(file, path, perm, b.waitRateLimiter)
+	} else {
+		err = os.Chmod(b.filename, perm)
+		if err != nil {
+			return errors.Wrapf(err, "can't change mode of a temp file '%s'", b.filename)
+		}
+
+		err = b.file.rename(path)
+		if isCrossDeviceError(err) {
+			err = copyToFile(file, path, perm, b.waitRateLimiter)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't commit a temp file '%s' to '%s'", b.filename, path)
+	}
+
+	b.Reset()
+
+	return nil
+}
+
+// moveToPlainFile moves unread data into a plain temp file. The file is used as a storage of the Buffer
+func (b *Buffer) moveToPlainFile() error {
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
+	}
+
+	err := b.finishWriting()
+	if err != nil {
+		return err
+	}
+
+	if b.file != nil && b.offset == 0 && b.buff.Len() == 0 && !b.fileReader.encrypt && b.fileReader.headerSize == 0 && !b.file.shared() {
+		// All data is already stored in a plain file
+		return nil
+	}
+
+	file, err := b.reuseOrOpenTempFile(b.size - b.offset)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 32<<10)
+	for off := b.offset; off < b.size; {
+		n, err := b.readAt(chunk, off)
+		if err == nil {
+			err = b.waitRateLimiter(n)
+		}
+		if err == nil {
+			_, err = file.Write(chunk[:n])
+		}
+		if err != nil {
+			file.release()
+			return errors.Wrap(err, "can't copy data into a temp file")
+		}
+		off += int64(n)
+	}
+
+	size := b.size - b.offset
+
+	// Replace the storage. Hashes cover all written bytes, so they must be kept
+	hashes := b.hashes
+	b.hashes = nil
+	b.Reset()
+	b.hashes = hashes
+	b.writingFinished = true
+	b.size = size
+	b.file = file
+	b.file.wipe = b.wipeFile
+	b.filename = file.Name()
+	b.fileSize = size
+	b.fileReader = fileReader{
+		file:   b.file,
+		waitIO: b.waitRateLimiter,
+	}
+
+	return nil
+}
+
+func isCrossDeviceError(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	return ok && linkErr.Err == syscall.EXDEV
+}
+
+// copyToFile copies data from r into a temp file in the directory of path and renames this file.
+// The file gets perm mode. wait is called for every read and written chunk (see Buffer.waitRateLimiter())
+func copyToFile(r io.Reader, path string, perm os.FileMode, wait func(n int) error) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(rateLimitedWriter{w: file, wait: wait}, rateLimitedReader{r: r, wait: wait})
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
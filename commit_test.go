@@ -0,0 +1,129 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_File(t *testing.T) {
+	tests := []struct {
+		desc     string
+		maxSize  int
+		encrypt  bool
+		dataSize int
+		readSize int
+		// sameFile is true when the original temp file must be reused
+		sameFile bool
+	}{
+		{desc: "empty", maxSize: 100, dataSize: 0},
+		{desc: "memory", maxSize: 100, dataSize: 50},
+		{desc: "memory and file", maxSize: 100, dataSize: 1000},
+		{desc: "file", maxSize: 0, dataSize: 1000, sameFile: true},
+		{desc: "file, after read", maxSize: 0, dataSize: 1000, readSize: 10},
+		{desc: "encrypted", maxSize: 0, encrypt: true, dataSize: 100 << 10},
+		{desc: "encrypted, after read", maxSize: 100, encrypt: true, dataSize: 100 << 10, readSize: 70 << 10},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+			_, err = io.ReadFull(b, make([]byte, tt.readSize))
+			require.Nil(err)
+
+			originalFilename := b.filename
+
+			file, err := b.File()
+			require.Nil(err)
+
+			if tt.sameFile {
+				require.Equal(originalFilename, file.Name())
+			} else if originalFilename != "" {
+				_, err = os.Stat(originalFilename)
+				require.True(os.IsNotExist(err), "old file must be removed")
+			}
+
+			res, err := ioutil.ReadAll(file)
+			require.Nil(err)
+			require.Equal(string(data[tt.readSize:]), string(res))
+
+			// The Buffer still contains the same data
+			require.Equal(len(data)-tt.readSize, b.Len())
+			res = readByChunks(require, b, 1000)
+			require.Equal(string(data[tt.readSize:]), string(res))
+
+			_, err = os.Stat(file.Name())
+			require.True(os.IsNotExist(err), "file must be removed")
+		})
+	}
+}
+
+func TestBuffer_CommitTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		desc     string
+		maxSize  int
+		encrypt  bool
+		dataSize int
+	}{
+		{desc: "memory", maxSize: 100, dataSize: 50},
+		{desc: "file", maxSize: 0, dataSize: 1000},
+		{desc: "memory and file", maxSize: 100, dataSize: 1000},
+		{desc: "encrypted", maxSize: 100, encrypt: true, dataSize: 100 << 10},
+	}
+
+	for i, tt := range tests {
+
+		t.Run(tt.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			path := filepath.Join(dir, string(rune('a'+i)))
+
+			err = b.CommitTo(path, 0644)
+			require.Nil(err)
+			require.Equal(0, b.Len())
+			require.Equal("", b.filename)
+
+			res, err := ioutil.ReadFile(path)
+			require.Nil(err)
+			require.Equal(data, res)
+
+			info, err := os.Stat(path)
+			require.Nil(err)
+			require.Equal(os.FileMode(0644), info.Mode().Perm())
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package buffer
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// SyncPolicy defines when a temp file is synced to stable storage
+type SyncPolicy int
+
+const (
+	// SyncNever never syncs temp files. It is the default policy
+	SyncNever SyncPolicy = 0
+	// SyncOnFinish syncs a temp file when writing is finished (on the first read, Buffer.NewReader() and etc.)
+	SyncOnFinish SyncPolicy = -1
+)
+
+// SyncEvery returns a policy that syncs a temp file after every n bytes written into the file
+// and when writing is finished. If n <= 0, it returns SyncOnFinish
+func SyncEvery(n int) SyncPolicy {
+	if n <= 0 {
+		return SyncOnFinish
+	}
+	return SyncPolicy(n)
+}
+
+// SetSyncPolicy sets a policy that decides when the temp file is synced. Note that encrypted data
+// is written into the file by whole packages, so the last incomplete package is synced only
+// when writing is finished
+func (b *Buffer) SetSyncPolicy(policy SyncPolicy) {
+	b.syncPolicy = policy
+}
+
+// SizeHint tells the Buffer that n bytes are going to be written in total. It is used to choose
+// the storage before the data is written:
+//
+//   - if n bytes fit in memory, the memory is allocated exactly for n bytes
+//   - if n bytes don't fit in memory, the memory is skipped and all data is written into a temp file,
+//     which is created by SizeHint. Disk space for the data is preallocated (with fallocate on Linux),
+//     so ENOSPC is returned by SizeHint, not by Buffer.Write()
+//
+// The storage is chosen only if no data was written yet. Otherwise, SizeHint preallocates disk
+// space for the rest of the data when the temp file is created.
+//
+// SizeHint returns ErrBufferFinished after the call of Buffer.Read(), Buffer.ReadByte() or Buffer.Next()
+func (b *Buffer) SizeHint(n int64) error {
+	if b.writingFinished {
+		return ErrBufferFinished
+	}
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
+	}
+	if n < 0 {
+		return ErrNegativeCount
+	}
+
+	b.sizeHint = n
+
+	if b.file != nil {
+		// The file already exists
+		return b.preallocateFile(b.file, n-b.size)
+	}
+	if b.size != 0 {
+		// The storage can't be changed
+		return nil
+	}
+
+	if n <= int64(b.maxMemorySize()) {
+		if b.buff.Cap() != int(n) {
+			b.replaceMemory(int(n))
+		}
+		return nil
+	}
+
+	// Write all data into the file
+	b.replaceMemory(0)
+	return b.createTempFile()
+}
+
+// replaceMemory replaces the memory with an empty buffer of passed capacity
+func (b *Buffer) replaceMemory(capacity int) {
+	if b.lockedMemory != nil {
+		// Locked memory is allocated once for maxInMemorySize bytes. It is reused as is
+		return
+	}
+
+	if b.secureWipe {
+		old := b.buff.Bytes()
+		wipe(old[:cap(old)])
+	}
+
+	var buff []byte
+	if capacity > 0 {
+		buff = make([]byte, 0, capacity)
+	}
+	b.buff = *bytes.NewBuffer(buff)
+}
+
+// preallocateFile preallocates disk space for n more bytes of data that will be written into the file
+func (b *Buffer) preallocateFile(file *tempFile, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+
+	off := b.physicalSize(b.fileSize)
+	return preallocateTempFile(file, b.fileReader.headerSize+off, b.physicalSize(b.fileSize+n)-off)
+}
+
+// preallocateTempFile preallocates disk space for the range [off, off+size) of the file
+func preallocateTempFile(file *tempFile, off, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	osFile, ok := file.TempFile.(*os.File)
+	if !ok {
+		// Only real files can be preallocated
+		return nil
+	}
+
+	err := preallocate(osFile, off, size)
+	if err != nil {
+		return errors.Wrapf(err, "can't preallocate space for a temp file '%s'", file.Name())
+	}
+	return nil
+}
+
+// physicalSize returns a number of bytes required to store n bytes of data in the file
+func (b *Buffer) physicalSize(n int64) int64 {
+	if !b.encrypt {
+		return n
+	}
+
+	packages := (n + encryptionPayloadSize - 1) / encryptionPayloadSize
+	return n + packages*(encryptedPackageSize-encryptionPayloadSize)
+}
+
+// fileWritten must be called after n bytes were written into the file. It syncs the file according
+// to the sync policy
+func (b *Buffer) fileWritten(n int) error {
+	if b.syncPolicy <= 0 {
+		return nil
+	}
+
+	b.unsyncedSize += int64(n)
+	if b.unsyncedSize < int64(b.syncPolicy) {
+		return nil
+	}
+	return b.syncFile()
+}
+
+// syncFile commits the content of the temp file to stable storage
+func (b *Buffer) syncFile() error {
+	b.unsyncedSize = 0
+
+	err := b.file.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "can't sync a temp file '%s'", b.filename)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package buffer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_SetSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SyncPolicy
+		encrypt bool
+		//
+		unsyncedSize int64
+	}{
+		{name: "never", policy: SyncNever, unsyncedSize: 0},
+		{name: "on finish", policy: SyncOnFinish, unsyncedSize: 0},
+		{name: "every 120 bytes", policy: SyncEvery(120), unsyncedSize: 100},
+		{name: "every 0 bytes", policy: SyncEvery(0), unsyncedSize: 0},
+		{name: "encrypted, every 120 bytes", policy: SyncEvery(120), encrypt: true, unsyncedSize: 100},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(10)
+			b.SetSyncPolicy(tt.policy)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			data := generateRandomString(250)
+			for i := 0; i < len(data); i += 50 {
+				_, err := b.WriteString(data[i : i+50])
+				require.Nil(err)
+			}
+			require.Equal(tt.unsyncedSize, b.unsyncedSize)
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, string(res))
+		})
+	}
+}
+
+func TestBuffer_SizeHint(t *testing.T) {
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		require.Equal(ErrNegativeCount, b.SizeHint(-1))
+
+		r := NewBufferWithMaxMemorySize(10)
+		require.Nil(r.EnableRingMode(100))
+		require.Equal(ErrRingModeUnsupported, r.SizeHint(100))
+
+		_, err := b.Read(make([]byte, 1))
+		require.NotNil(err)
+		require.Equal(ErrBufferFinished, b.SizeHint(100))
+	})
+
+	t.Run("physical size", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Equal(int64(100), b.physicalSize(100))
+
+		require.Nil(b.EnableEncryption())
+		require.Equal(int64(0), b.physicalSize(0))
+		require.Equal(int64(100+32), b.physicalSize(100))
+		require.Equal(int64(encryptedPackageSize), b.physicalSize(encryptionPayloadSize))
+		require.Equal(int64(encryptedPackageSize+1+32), b.physicalSize(encryptionPayloadSize+1))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		b.EnableSecureWipe(false)
+		defer b.Close()
+
+		require.Nil(b.SizeHint(30))
+		require.Equal(30, b.buff.Cap())
+
+		data := generateRandomString(30)
+		_, err := b.WriteString(data)
+		require.Nil(err)
+		require.Nil(b.file)
+		require.Equal(30, b.buff.Cap())
+
+		// The storage can't be changed after writing
+		require.Nil(b.SizeHint(1000))
+		require.Nil(b.file)
+
+		res, err := ioutil.ReadAll(b)
+		require.Nil(err)
+		require.Equal(data, string(res))
+	})
+
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+		t.Run(fmt.Sprintf("write (encrypt: %t)", encrypt), func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(10)
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			data := generateRandomString(1 << 17)
+			require.Nil(b.SizeHint(int64(len(data))))
+
+			_, err := b.WriteString(data)
+			require.Nil(err)
+
+			// The hint can be changed after the file creation
+			require.Nil(b.SizeHint(int64(len(data)) * 2))
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, string(res))
+		})
+	}
+}
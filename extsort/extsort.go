@@ -0,0 +1,404 @@
+// Package extsort sorts datasets that don't fit in RAM. Records are collected into sorted runs, which
+// are stored in buffer.Buffer (in memory or in temp files). Then the runs are merged
+package extsort
+
+import (
+	"bufio"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+const (
+	// DefaultMemoryBudget is used when Options.MemoryBudget is zero
+	DefaultMemoryBudget = 64 << 20 // 64 MB
+
+	// recordOverhead is an approximate number of bytes used by a record in memory in addition to its data
+	recordOverhead = 24
+
+	// minReadBufferSize and maxReadBufferSize limit the size of a read buffer of a run during the merge
+	minReadBufferSize = 4 << 10 // 4 KB
+	maxReadBufferSize = 1 << 20 // 1 MB
+	// decryptedPackageSize is a number of bytes used by a reader of an encrypted temp file to cache
+	// the last decrypted package
+	decryptedPackageSize = 64 << 10 // 64 KB
+)
+
+var (
+	// ErrSorterClosed is used when records are added after Sorter.Sort() or Sorter.Close()
+	ErrSorterClosed = errors.New("sorter is closed")
+)
+
+// Less reports whether record a must be placed before record b
+type Less func(a, b []byte) bool
+
+// RecordIterator is an iterator over records. It is implemented by *buffer.RecordIterator and *Merger
+type RecordIterator interface {
+	Next() bool
+	Record() []byte
+	Err() error
+}
+
+// Options are used to configure Sorter
+type Options struct {
+	// MemoryBudget is a max number of bytes used by records in memory. A half of the budget is used
+	// to sort a run, the other half – to store runs in memory. During the merge the first half is used
+	// by read buffers of runs. If there are too many runs, they are merged in several passes.
+	// DefaultMemoryBudget is used by default
+	MemoryBudget int
+	// TempDir is a directory for temp files. os.TempDir() is used by default
+	TempDir string
+	// Encrypt enables encryption of temp files
+	Encrypt bool
+}
+
+// Sorter sorts records with a user comparator. It isn't thread-safe!
+//
+// Usage:
+//
+//	s := extsort.New(less, extsort.Options{})
+//	defer s.Close()
+//
+//	for ... {
+//		err := s.Add(record)
+//		...
+//	}
+//
+//	m, err := s.Sort()
+//	...
+//	defer m.Close()
+//
+//	for m.Next() {
+//		record := m.Record()
+//		...
+//	}
+//	if err := m.Err(); err != nil {
+//		...
+//	}
+type Sorter struct {
+	less Less
+	opts Options
+
+	// runBudget is a max number of bytes of records that are sorted in memory
+	runBudget int
+	// spillBudget is a number of bytes that can be used by runs in memory
+	spillBudget int
+
+	records     [][]byte
+	recordsSize int
+
+	runs   []run
+	closed bool
+}
+
+// run is a sorted run
+type run struct {
+	b *buffer.Buffer
+	// memory is a number of bytes of the spill budget used by the run
+	memory int
+}
+
+// New creates a new Sorter
+func New(less Less, opts Options) *Sorter {
+	if opts.MemoryBudget <= 0 {
+		opts.MemoryBudget = DefaultMemoryBudget
+	}
+
+	return &Sorter{
+		less:        less,
+		opts:        opts,
+		runBudget:   opts.MemoryBudget / 2,
+		spillBudget: opts.MemoryBudget - opts.MemoryBudget/2,
+	}
+}
+
+// Add adds a record. The record is copied, so it can be reused by the caller
+func (s *Sorter) Add(record []byte) error {
+	if s.closed {
+		return ErrSorterClosed
+	}
+
+	s.records = append(s.records, append([]byte(nil), record...))
+	s.recordsSize += len(record) + recordOverhead
+
+	if s.recordsSize >= s.runBudget {
+		return s.flushRun()
+	}
+	return nil
+}
+
+// AddFrom adds all records of iter
+func (s *Sorter) AddFrom(iter RecordIterator) error {
+	for iter.Next() {
+		err := s.Add(iter.Record())
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// AddFromReader reads records from r until EOF. Records are split by split function (bufio.ScanLines, for example)
+func (s *Sorter) AddFromReader(r io.Reader, split bufio.SplitFunc) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), s.runBudget)
+	scanner.Split(split)
+
+	for scanner.Scan() {
+		err := s.Add(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "can't read records")
+	}
+	return nil
+}
+
+// flushRun sorts collected records and writes them into a new run
+func (s *Sorter) flushRun() error {
+	if len(s.records) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(s.records, func(i, j int) bool {
+		return s.less(s.records[i], s.records[j])
+	})
+
+	var size int64
+	for _, record := range s.records {
+		size += int64(uvarintSize(len(record)) + len(record))
+	}
+
+	run, err := s.newRun(size)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+
+	for i, record := range s.records {
+		err := run.b.WriteRecord(record)
+		if err != nil {
+			return errors.Wrap(err, "can't write a run")
+		}
+		s.records[i] = nil
+	}
+
+	s.records = s.records[:0]
+	s.recordsSize = 0
+
+	return nil
+}
+
+// newRun creates a Buffer for a new run of passed size. The run is stored in memory if it fits in the rest
+// of the spill budget. Otherwise, it is written directly into a temp file
+func (s *Sorter) newRun(size int64) (run, error) {
+	maxMemorySize := s.spillBudget
+	if int64(maxMemorySize) > size {
+		maxMemorySize = int(size)
+	}
+
+	b := buffer.NewBufferWithMaxMemorySize(maxMemorySize)
+	if s.opts.TempDir != "" {
+		err := b.ChangeTempDir(s.opts.TempDir)
+		if err != nil {
+			return run{}, err
+		}
+	}
+	if s.opts.Encrypt {
+		err := b.EnableEncryption()
+		if err != nil {
+			return run{}, err
+		}
+	}
+
+	err := b.SizeHint(size)
+	if err != nil {
+		b.Close()
+		return run{}, err
+	}
+
+	r := run{b: b}
+	if size <= int64(s.spillBudget) {
+		r.memory = int(size)
+		s.spillBudget -= r.memory
+	}
+
+	return r, nil
+}
+
+// Sort sorts all added records and returns a Merger that yields them in order. Records can't be added
+// after the call of Sort
+func (s *Sorter) Sort() (*Merger, error) {
+	if s.closed {
+		return nil, ErrSorterClosed
+	}
+	s.closed = true
+
+	err := s.flushRun()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	err = s.mergeRuns()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	m, err := newMerger(s.runs, s.less, s.readBufferSize(len(s.runs)))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Runs are owned by the Merger now
+	s.runs = nil
+
+	return m, nil
+}
+
+// Close removes all runs. It must be called if Sort isn't called or fails
+func (s *Sorter) Close() error {
+	s.closed = true
+	s.records = nil
+	s.recordsSize = 0
+
+	err := closeRuns(s.runs)
+	s.runs = nil
+
+	return err
+}
+
+// mergeRuns merges groups of runs into larger runs until all runs can be merged at once
+// within the memory budget
+func (s *Sorter) mergeRuns() error {
+	fanIn := s.maxFanIn()
+	for len(s.runs) > fanIn {
+		var merged []run
+		for len(s.runs) > 0 {
+			n := fanIn
+			if n > len(s.runs) {
+				n = len(s.runs)
+			}
+
+			run, err := s.mergeGroup(s.runs[:n])
+			if err != nil {
+				// Sorter must close all runs
+				s.runs = append(merged, s.runs[n:]...)
+				return err
+			}
+			merged = append(merged, run)
+			s.runs = s.runs[n:]
+		}
+		s.runs = merged
+	}
+	return nil
+}
+
+// mergeGroup merges runs into a new run. The passed runs are closed
+func (s *Sorter) mergeGroup(runs []run) (run, error) {
+	if len(runs) == 1 {
+		return runs[0], nil
+	}
+
+	var size int64
+	for _, run := range runs {
+		size += run.b.Remaining()
+	}
+
+	m, err := newMerger(runs, s.less, s.readBufferSize(len(runs)))
+	if err != nil {
+		return run{}, err
+	}
+	defer func() {
+		m.Close()
+		for _, run := range runs {
+			s.spillBudget += run.memory
+		}
+	}()
+
+	res, err := s.newRun(size)
+	if err != nil {
+		return run{}, err
+	}
+	for m.Next() {
+		err := res.b.WriteRecord(m.Record())
+		if err != nil {
+			res.b.Close()
+			s.spillBudget += res.memory
+			return run{}, errors.Wrap(err, "can't write a run")
+		}
+	}
+	if err := m.Err(); err != nil {
+		res.b.Close()
+		s.spillBudget += res.memory
+		return run{}, err
+	}
+
+	return res, nil
+}
+
+// runReaderOverhead returns a number of bytes used by a reader of a run in addition to its read buffer
+func (s *Sorter) runReaderOverhead() int {
+	if s.opts.Encrypt {
+		return decryptedPackageSize
+	}
+	return 0
+}
+
+// maxFanIn returns the max number of runs that can be merged at once. Every run needs a read buffer
+// of at least minReadBufferSize bytes. At least 2 runs are merged at once
+func (s *Sorter) maxFanIn() int {
+	n := s.runBudget / (minReadBufferSize + s.runReaderOverhead())
+	if n < 2 {
+		n = 2
+	}
+	return n
+}
+
+// readBufferSize returns the size of a read buffer of a run when n runs are merged at once
+func (s *Sorter) readBufferSize(n int) int {
+	if n == 0 {
+		return minReadBufferSize
+	}
+
+	size := s.runBudget/n - s.runReaderOverhead()
+	switch {
+	case size < minReadBufferSize:
+		size = minReadBufferSize
+	case size > maxReadBufferSize:
+		size = maxReadBufferSize
+	}
+	return size
+}
+
+func closeRuns(runs []run) error {
+	var err error
+	for _, run := range runs {
+		if closeErr := run.b.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+func uvarintSize(n int) int {
+	return uvarintSize64(uint64(n))
+}
+
+func uvarintSize64(n uint64) int {
+	size := 1
+	for n >= 0x80 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+// maxInt is the max value of int
+const maxInt = int(^uint(0) >> 1)
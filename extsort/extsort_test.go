@@ -0,0 +1,231 @@
+package extsort
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+func generateRecords(count, maxSize int) [][]byte {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+
+	records := make([][]byte, count)
+	for i := range records {
+		record := make([]byte, rand.Intn(maxSize+1))
+		for j := range record {
+			record[j] = letters[rand.Intn(len(letters))]
+		}
+		records[i] = record
+	}
+	return records
+}
+
+func TestSorter(t *testing.T) {
+	tests := []struct {
+		name         string
+		count        int
+		maxSize      int
+		memoryBudget int
+		encrypt      bool
+	}{
+		{name: "empty", count: 0, maxSize: 10, memoryBudget: 1 << 10},
+		{name: "single run", count: 100, maxSize: 10, memoryBudget: 1 << 20},
+		{name: "runs in memory", count: 1000, maxSize: 20, memoryBudget: 64 << 10},
+		{name: "runs on disk", count: 5000, maxSize: 100, memoryBudget: 16 << 10},
+		{name: "encrypted runs", count: 5000, maxSize: 100, memoryBudget: 16 << 10, encrypt: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+			require.Nil(err)
+			defer os.RemoveAll(dir)
+
+			records := generateRecords(tt.count, tt.maxSize)
+
+			s := New(
+				func(a, b []byte) bool { return bytes.Compare(a, b) < 0 },
+				Options{MemoryBudget: tt.memoryBudget, TempDir: dir, Encrypt: tt.encrypt},
+			)
+			defer s.Close()
+
+			for _, record := range records {
+				require.Nil(s.Add(record))
+			}
+
+			m, err := s.Sort()
+			require.Nil(err)
+
+			var res [][]byte
+			for m.Next() {
+				res = append(res, m.Record())
+			}
+			require.Nil(m.Err())
+
+			sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i], records[j]) < 0 })
+			require.Equal(len(records), len(res))
+			for i := range records {
+				require.Equal(string(records[i]), string(res[i]))
+			}
+
+			require.Nil(m.Close())
+
+			files, err := ioutil.ReadDir(dir)
+			require.Nil(err)
+			require.Len(files, 0, "temp files must be removed")
+		})
+	}
+}
+
+func TestSorter_Stable(t *testing.T) {
+	require := require.New(t)
+
+	// Records are compared by the first byte only
+	s := New(func(a, b []byte) bool { return a[0] < b[0] }, Options{MemoryBudget: 256})
+	defer s.Close()
+
+	var records []string
+	for i := 0; i < 100; i++ {
+		record := string([]byte{byte('a' + i%3), byte('0' + i/10), byte('0' + i%10)})
+		records = append(records, record)
+		require.Nil(s.Add([]byte(record)))
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i][0] < records[j][0] })
+
+	m, err := s.Sort()
+	require.Nil(err)
+	defer m.Close()
+
+	var res []string
+	for m.Next() {
+		res = append(res, string(m.Record()))
+	}
+	require.Nil(m.Err())
+	require.Equal(records, res)
+}
+
+func TestSorter_AddFromReader(t *testing.T) {
+	require := require.New(t)
+
+	lines := []string{"delta", "alpha", "charlie", "echo", "bravo"}
+
+	s := New(func(a, b []byte) bool { return bytes.Compare(a, b) < 0 }, Options{MemoryBudget: 64})
+	defer s.Close()
+
+	require.Nil(s.AddFromReader(strings.NewReader(strings.Join(lines, "\n")), bufio.ScanLines))
+
+	m, err := s.Sort()
+	require.Nil(err)
+	defer m.Close()
+
+	w := &bytes.Buffer{}
+	_, err = m.WriteRecords(w, []byte("\n"))
+	require.Nil(err)
+	require.Equal("alpha\nbravo\ncharlie\ndelta\necho\n", w.String())
+}
+
+func TestSorter_AddFrom(t *testing.T) {
+	require := require.New(t)
+
+	b := buffer.NewBufferWithMaxMemorySize(10)
+	defer b.Close()
+
+	for _, record := range []string{"3", "1", "2"} {
+		require.Nil(b.WriteRecord([]byte(record)))
+	}
+
+	s := New(func(a, b []byte) bool { return bytes.Compare(a, b) < 0 }, Options{})
+	defer s.Close()
+
+	require.Nil(s.AddFrom(b.Records()))
+
+	m, err := s.Sort()
+	require.Nil(err)
+	defer m.Close()
+
+	w := &bytes.Buffer{}
+	_, err = m.WriteRecords(w, nil)
+	require.Nil(err)
+	require.Equal("123", w.String())
+
+	// Sorter is closed after Sort
+	require.Equal(ErrSorterClosed, s.Add([]byte("4")))
+	_, err = s.Sort()
+	require.Equal(ErrSorterClosed, err)
+}
+
+func TestSorter_MultiPassMerge(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		require := require.New(t)
+
+		dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+		require.Nil(err)
+		defer os.RemoveAll(dir)
+
+		records := generateRecords(20000, 50)
+
+		s := New(
+			func(a, b []byte) bool { return bytes.Compare(a, b) < 0 },
+			Options{MemoryBudget: 20 << 10, TempDir: dir, Encrypt: encrypt},
+		)
+		defer s.Close()
+
+		for _, record := range records {
+			require.Nil(s.Add(record))
+		}
+		fanIn := s.maxFanIn()
+		require.True(len(s.runs) > fanIn, "runs must be merged in several passes")
+
+		m, err := s.Sort()
+		require.Nil(err)
+		require.True(len(m.readers) <= fanIn)
+
+		var res [][]byte
+		for m.Next() {
+			res = append(res, m.Record())
+		}
+		require.Nil(m.Err())
+
+		sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i], records[j]) < 0 })
+		require.Equal(len(records), len(res))
+		for i := range records {
+			require.Equal(string(records[i]), string(res[i]))
+		}
+
+		require.Nil(m.Close())
+
+		files, err := ioutil.ReadDir(dir)
+		require.Nil(err)
+		require.Len(files, 0, "temp files must be removed")
+	}
+}
+
+func TestSorter_ReadBufferSize(t *testing.T) {
+	require := require.New(t)
+
+	s := New(nil, Options{MemoryBudget: 64 << 20})
+	require.Equal(8192, s.maxFanIn())
+	require.Equal(maxReadBufferSize, s.readBufferSize(1))
+	require.Equal(32<<20/100, s.readBufferSize(100))
+	require.Equal(minReadBufferSize, s.readBufferSize(10000))
+
+	s = New(nil, Options{MemoryBudget: 64 << 20, Encrypt: true})
+	require.Equal(32<<20/(minReadBufferSize+decryptedPackageSize), s.maxFanIn())
+	require.Equal(32<<20/100-decryptedPackageSize, s.readBufferSize(100))
+
+	s = New(nil, Options{MemoryBudget: 1 << 10})
+	require.Equal(2, s.maxFanIn())
+}
@@ -0,0 +1,222 @@
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+// Merger merges sorted runs. It isn't thread-safe!
+type Merger struct {
+	runs    []run
+	readers []*runReader
+	heap    runHeap
+	record  []byte
+	err     error
+}
+
+// newMerger creates a Merger of runs. Runs are read through buffers of passed size. Runs are owned
+// by the Merger even if newMerger fails
+func newMerger(runs []run, less Less, readBufferSize int) (*Merger, error) {
+	m := &Merger{
+		runs: runs,
+		heap: runHeap{
+			less: less,
+		},
+	}
+
+	for i, run := range runs {
+		r, err := newRunReader(run.b, readBufferSize)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.readers = append(m.readers, r)
+
+		record, err := r.readRecord()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		m.heap.cursors = append(m.heap.cursors, cursor{reader: r, index: i, record: record})
+	}
+	heap.Init(&m.heap)
+
+	return m, nil
+}
+
+// Next reads the next record. It returns false when there are no more records or an error occurred
+func (m *Merger) Next() bool {
+	if m.err != nil || len(m.heap.cursors) == 0 {
+		m.record = nil
+		return false
+	}
+
+	top := &m.heap.cursors[0]
+	m.record = top.record
+
+	record, err := top.reader.readRecord()
+	switch err {
+	case nil:
+		top.record = record
+		heap.Fix(&m.heap, 0)
+	case io.EOF:
+		heap.Pop(&m.heap)
+	default:
+		m.err = err
+		return false
+	}
+
+	return true
+}
+
+// Record returns the record read by the last call of Next
+func (m *Merger) Record() []byte {
+	return m.record
+}
+
+// Err returns the first error that occurred during the merge
+func (m *Merger) Err() error {
+	return m.err
+}
+
+// WriteRecords writes all records to w. Every record is followed by sep
+func (m *Merger) WriteRecords(w io.Writer, sep []byte) (n int64, err error) {
+	for m.Next() {
+		n1, err := w.Write(m.Record())
+		n += int64(n1)
+		if err != nil {
+			return n, err
+		}
+
+		n1, err = w.Write(sep)
+		n += int64(n1)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, m.Err()
+}
+
+// Close removes all runs
+func (m *Merger) Close() error {
+	var err error
+	for _, r := range m.readers {
+		if closeErr := r.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := closeRuns(m.runs); err == nil {
+		err = closeErr
+	}
+	m.runs = nil
+	m.readers = nil
+	m.heap.cursors = nil
+
+	return err
+}
+
+// runReader reads records of a run through a buffer. So, records stored on a disk are read
+// by large chunks instead of a couple of syscalls per record
+type runReader struct {
+	r  *buffer.Reader
+	br *bufio.Reader
+	// remaining is a number of unread bytes of the run
+	remaining int64
+}
+
+func newRunReader(b *buffer.Buffer, size int) (*runReader, error) {
+	r, err := b.NewReader()
+	if err != nil {
+		return nil, err
+	}
+	return &runReader{
+		r:         r,
+		br:        bufio.NewReaderSize(r, size),
+		remaining: r.Size(),
+	}, nil
+}
+
+// readRecord reads a record written by buffer.Buffer.WriteRecord(). It returns io.EOF if there are no more records
+func (r *runReader) readRecord() ([]byte, error) {
+	length, err := binary.ReadUvarint(r.br)
+	switch {
+	case err == io.EOF:
+		return nil, io.EOF
+	case err == io.ErrUnexpectedEOF:
+		return nil, buffer.ErrTruncatedRecord
+	case err != nil:
+		return nil, errors.Wrap(err, "can't read a record length")
+	}
+
+	r.remaining -= int64(uvarintSize64(length))
+	switch {
+	case length > uint64(maxInt):
+		return nil, buffer.ErrInvalidRecordLength
+	case r.remaining < 0 || length > uint64(r.remaining):
+		return nil, buffer.ErrTruncatedRecord
+	}
+
+	record := make([]byte, length)
+	_, err = io.ReadFull(r.br, record)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, buffer.ErrTruncatedRecord
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read a record")
+	}
+	r.remaining -= int64(length)
+
+	return record, nil
+}
+
+func (r *runReader) close() error {
+	r.br = nil
+	return r.r.Close()
+}
+
+// cursor is the current record of a run
+type cursor struct {
+	reader *runReader
+	index  int
+	record []byte
+}
+
+// runHeap is a min-heap of cursors. Records from earlier runs go first if they are equal, so the sort is stable
+type runHeap struct {
+	cursors []cursor
+	less    Less
+}
+
+func (h runHeap) Len() int { return len(h.cursors) }
+
+func (h runHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if h.less(a.record, b.record) {
+		return true
+	}
+	if h.less(b.record, a.record) {
+		return false
+	}
+	return a.index < b.index
+}
+
+func (h runHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *runHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(cursor)) }
+
+func (h *runHeap) Pop() interface{} {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,257 @@
+package buffer
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/minio/sio"
+	"github.com/pkg/errors"
+)
+
+// TempFile is a file used by Buffer to store data on a disk. *os.File implements it. Buffer removes
+// the file with os.Remove(file.Name())
+type TempFile interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	Name() string
+	Sync() error
+	Truncate(size int64) error
+}
+
+// TempFileCreator creates a new temp file in passed directory. If dir is empty, os.TempDir() must be used
+type TempFileCreator func(dir string) (TempFile, error)
+
+// tempFile is a temp file shared by Buffer and its Readers. The file is closed and removed
+// when all of them release it
+type tempFile struct {
+	TempFile
+
+	refs int32
+	// renamed is true when the file was moved by Buffer.CommitTo(). It must not be removed
+	renamed bool
+	// wipe is true when the file must be overwritten with zeros before removal
+	wipe bool
+	// anonymous is true when the file has no name, so it mustn't be removed
+	anonymous bool
+}
+
+func newTempFile(file TempFile) *tempFile {
+	return &tempFile{
+		TempFile: file,
+		refs:     1,
+	}
+}
+
+func (f *tempFile) retain() {
+	atomic.AddInt32(&f.refs, 1)
+}
+
+// shared returns true if the file is used by someone else
+func (f *tempFile) shared() bool {
+	return atomic.LoadInt32(&f.refs) > 1
+}
+
+// release closes and removes the file if nobody uses it
+func (f *tempFile) release() error {
+	if atomic.AddInt32(&f.refs, -1) != 0 {
+		return nil
+	}
+
+	if f.renamed {
+		return f.Close()
+	}
+
+	var wipeErr error
+	if f.wipe {
+		wipeErr = wipeFileContent(f.TempFile)
+	}
+
+	f.Close()
+	if f.anonymous {
+		return wipeErr
+	}
+
+	err := os.Remove(f.Name())
+	if err != nil {
+		return errors.Wrapf(err, "can't remove a temp file '%s'", f.Name())
+	}
+	return wipeErr
+}
+
+// rename moves the file. The file isn't removed after that
+func (f *tempFile) rename(path string) error {
+	err := os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	f.renamed = true
+	return nil
+}
+
+// encryptionConfig returns a config for sio package. DARE 1.0 is used because its packages are
+// independent of each other. So, we can decrypt any package of the file and truncate the file
+// by packages. All packages except the last one have the same size – encryptedPackageSize.
+// DARE 1.0 has no flag for the final package, so a file cut at a package boundary must be detected
+// by the caller (Buffer knows the size of the data).
+// If cipherSuites is nil, sio chooses the cipher suite
+func encryptionConfig(key []byte, seqNum uint32, cipherSuites []byte) sio.Config {
+	return sio.Config{
+		MinVersion:     sio.Version10,
+		MaxVersion:     sio.Version10,
+		Key:            key,
+		SequenceNumber: seqNum,
+		CipherSuites:   cipherSuites,
+	}
+}
+
+// fileReader reads data from a temp file and decrypts it if needed. It caches the last
+// decrypted package, so it can't be used concurrently
+type fileReader struct {
+	file *tempFile
+
+	encrypt       bool
+	encryptionKey []byte
+	// cipherSuites are passed to sio. They are set only for files with a header
+	cipherSuites []byte
+
+	// headerSize is a size of the file header. It is 0 if the file has no header
+	headerSize int64
+	// headerChecked is true when the header was checked by checkHeader
+	headerChecked bool
+	// length is a logical length of the file from the header
+	length int64
+
+	// decryptedPackage is the last decrypted package. It allows to read the file
+	// by small chunks without decrypting the same package again and again
+	decryptedPackage      []byte
+	decryptedPackageIndex int64
+	// packageBuffer is used for decrypted packages instead of a new slice if it isn't nil. It is
+	// stored in locked memory if memory lock is enabled
+	packageBuffer []byte
+
+	// waitIO is called before reading of n bytes from the file. It is used for rate limiting. It can be nil
+	waitIO func(n int) error
+}
+
+// readAt fills data with the file content starting at passed offset
+func (r *fileReader) readAt(data []byte, off int64) (n int, err error) {
+	err = r.checkHeader()
+	if err != nil {
+		return 0, err
+	}
+	if r.headerSize != 0 && off+int64(len(data)) > r.length {
+		return 0, errors.Wrapf(io.ErrUnexpectedEOF, "can't read beyond the length of a temp file '%s'", r.file.Name())
+	}
+
+	if !r.encrypt {
+		err = r.wait(len(data))
+		if err != nil {
+			return 0, err
+		}
+
+		n, err = r.file.ReadAt(data, r.headerSize+off)
+		if err != nil {
+			return n, errors.Wrapf(err, "can't read from a temp file '%s'", r.file.Name())
+		}
+		return n, nil
+	}
+
+	for len(data) > 0 {
+		pkg, err := r.decryptPackage(off / encryptionPayloadSize)
+		if err != nil {
+			return n, err
+		}
+
+		pkgOffset := off % encryptionPayloadSize
+		if pkgOffset >= int64(len(pkg)) {
+			return n, errors.Wrapf(io.ErrUnexpectedEOF, "can't read from a temp file '%s'", r.file.Name())
+		}
+
+		copied := copy(data, pkg[pkgOffset:])
+		data = data[copied:]
+		off += int64(copied)
+		n += copied
+	}
+
+	return n, nil
+}
+
+// decryptPackage reads and decrypts a package with passed index
+func (r *fileReader) decryptPackage(index int64) ([]byte, error) {
+	if r.decryptedPackage != nil && r.decryptedPackageIndex == index {
+		return r.decryptedPackage, nil
+	}
+
+	err := r.wait(encryptedPackageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	section := io.NewSectionReader(r.file, r.headerSize+index*encryptedPackageSize, encryptedPackageSize)
+	reader, err := sio.DecryptReader(section, encryptionConfig(r.encryptionKey, uint32(index), r.cipherSuites))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create a decryption stream")
+	}
+
+	pkg := r.decryptedPackage
+	if pkg == nil {
+		pkg = r.packageBuffer
+	}
+	if pkg == nil {
+		pkg = make([]byte, encryptionPayloadSize)
+	}
+	pkg = pkg[:cap(pkg)]
+
+	n, err := io.ReadFull(reader, pkg)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		// io.ErrUnexpectedEOF is expected for the last package
+		r.decryptedPackage = nil
+		return nil, errors.Wrapf(err, "can't decrypt a package of a temp file '%s'", r.file.Name())
+	}
+
+	r.decryptedPackage = pkg[:n]
+	r.decryptedPackageIndex = index
+
+	return r.decryptedPackage, nil
+}
+
+func (r *fileReader) wait(n int) error {
+	if r.waitIO == nil {
+		return nil
+	}
+	return r.waitIO(n)
+}
+
+// wipe zeroes the encryption key and the decrypted package
+func (r *fileReader) wipe() {
+	wipe(r.encryptionKey)
+	wipe(r.decryptedPackage[:cap(r.decryptedPackage)])
+}
+
+// resetCache must be called after the file modification
+func (r *fileReader) resetCache() {
+	r.decryptedPackage = nil
+}
+
+// readAt reads data starting at passed offset from memory and then from the file.
+// The caller must check that there's enough data
+func readAt(memory []byte, file *fileReader, data []byte, off int64) (n int, err error) {
+	if off < int64(len(memory)) {
+		n = copy(data, memory[off:])
+		data = data[n:]
+		off += int64(n)
+	}
+	if len(data) == 0 {
+		return n, nil
+	}
+
+	n1, err := file.readAt(data, off-int64(len(memory)))
+	return n + n1, err
+}
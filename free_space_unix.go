@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package buffer
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// freeSpace returns a number of bytes available for unprivileged users in the filesystem of dir
+func freeSpace(dir string) (size int64, ok bool, err error) {
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "can't get stats of the filesystem of '%s'", dir)
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), true, nil
+}
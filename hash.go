@@ -0,0 +1,101 @@
+package buffer
+
+import (
+	"hash"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownHash is used when Buffer.Sum() is called with a name of a hash that wasn't added
+	ErrUnknownHash = errors.New("unknown hash")
+)
+
+// AddHash attaches a hash that is updated by Buffer.Write() with all written bytes, both stored in memory
+// and on a disk. The digest can be got with Buffer.Sum(name). Hashes must be added before writing.
+//
+// Buffer.WriteAt() and Buffer.Truncate() can't be applied to hashes on the fly. So, after them all written
+// bytes are read and hashed again when writing is finished
+func (b *Buffer) AddHash(name string, h hash.Hash) error {
+	if b.size != 0 {
+		return errors.New("hashes must be added before writing")
+	}
+	if _, ok := b.hashes[name]; ok {
+		return errors.Errorf("hash '%s' is already added", name)
+	}
+
+	if b.hashes == nil {
+		b.hashes = make(map[string]hash.Hash)
+	}
+	b.hashes[name] = h
+
+	return nil
+}
+
+// Sum returns the digest of all written bytes computed by the hash with passed name.
+// It returns ErrUnknownHash if there's no such hash.
+//
+// Sum finishes writing as Buffer.Read() does
+func (b *Buffer) Sum(name string) ([]byte, error) {
+	h, ok := b.hashes[name]
+	if !ok {
+		return nil, ErrUnknownHash
+	}
+
+	err := b.finishWriting()
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// writeHashes updates hashes with written data
+func (b *Buffer) writeHashes(data []byte) {
+	if b.hashesOutdated {
+		// Hashes will be computed again anyway
+		return
+	}
+
+	for _, h := range b.hashes {
+		// hash.Hash.Write never returns an error
+		h.Write(data)
+	}
+}
+
+// resetHashes resets all hashes
+func (b *Buffer) resetHashes() {
+	for _, h := range b.hashes {
+		h.Reset()
+	}
+	b.hashesOutdated = false
+}
+
+// invalidateHashes must be called when already written bytes are changed. Hashes are computed
+// again by Buffer.updateHashes()
+func (b *Buffer) invalidateHashes() {
+	if len(b.hashes) != 0 {
+		b.hashesOutdated = true
+	}
+}
+
+// updateHashes computes hashes again if they are outdated
+func (b *Buffer) updateHashes() error {
+	if !b.hashesOutdated {
+		return nil
+	}
+	b.resetHashes()
+
+	chunk := make([]byte, 32<<10)
+	for off := int64(0); off < b.size; {
+		n, err := b.readAt(chunk, off)
+		if err != nil {
+			b.hashesOutdated = true
+			return errors.Wrap(err, "can't compute hashes")
+		}
+		b.writeHashes(chunk[:n])
+		off += int64(n)
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package buffer
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_AddHash(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxInMemorySize int
+		encrypt         bool
+		chunkSize       int
+	}{
+		{name: "memory", maxInMemorySize: 1 << 20, chunkSize: 100},
+		{name: "memory and disk", maxInMemorySize: 1 << 10, chunkSize: 333},
+		{name: "disk", maxInMemorySize: 0, chunkSize: 1 << 10},
+		{name: "encrypted", maxInMemorySize: 1 << 10, encrypt: true, chunkSize: 7 << 10},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(tt.maxInMemorySize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			require.Nil(b.AddHash("sha256", sha256.New()))
+			require.Nil(b.AddHash("md5", md5.New()))
+			defer b.Close()
+
+			data := generateRandomString(100 << 10)
+			for i := 0; i < len(data); i += tt.chunkSize {
+				end := i + tt.chunkSize
+				if end > len(data) {
+					end = len(data)
+				}
+				_, err := b.WriteString(data[i:end])
+				require.Nil(err)
+			}
+
+			sha256Sum := sha256.Sum256([]byte(data))
+			md5Sum := md5.Sum([]byte(data))
+
+			sum, err := b.Sum("sha256")
+			require.Nil(err)
+			require.Equal(sha256Sum[:], sum)
+
+			sum, err = b.Sum("md5")
+			require.Nil(err)
+			require.Equal(md5Sum[:], sum)
+
+			_, err = b.Sum("sha1")
+			require.Equal(ErrUnknownHash, err)
+
+			// Data can be read after Sum
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, string(res))
+
+			sum, err = b.Sum("sha256")
+			require.Nil(err)
+			require.Equal(sha256Sum[:], sum)
+
+			// Hashes are reset
+			b.Reset()
+			emptySum := sha256.Sum256(nil)
+			sum, err = b.Sum("sha256")
+			require.Nil(err)
+			require.Equal(emptySum[:], sum)
+		})
+	}
+}
+
+func TestBuffer_AddHash_Changes(t *testing.T) {
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		require.Nil(b.AddHash("sha256", sha256.New()))
+		require.NotNil(b.AddHash("sha256", sha256.New()))
+
+		_, err := b.Write([]byte("hello"))
+		require.Nil(err)
+		require.NotNil(b.AddHash("md5", md5.New()))
+	})
+
+	t.Run("WriteAt", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.AddHash("sha256", sha256.New()))
+		defer b.Close()
+
+		data := []byte(generateRandomString(100))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		_, err = b.WriteAt([]byte("header"), 0)
+		require.Nil(err)
+		_, err = b.WriteAt([]byte("footer"), 90)
+		require.Nil(err)
+		copy(data, "header")
+		copy(data[90:], "footer")
+
+		expected := sha256.Sum256(data)
+		sum, err := b.Sum("sha256")
+		require.Nil(err)
+		require.Equal(expected[:], sum)
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.AddHash("sha256", sha256.New()))
+		defer b.Close()
+
+		data := []byte(generateRandomString(100))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		// Before reading
+		require.Nil(b.Truncate(80))
+		data = data[:80]
+
+		expected := sha256.Sum256(data)
+		sum, err := b.Sum("sha256")
+		require.Nil(err)
+		require.Equal(expected[:], sum)
+
+		// After reading
+		_, err = b.Read(make([]byte, 20))
+		require.Nil(err)
+		require.Nil(b.Truncate(10))
+		data = data[:30]
+
+		_, err = ioutil.ReadAll(b)
+		require.Nil(err)
+
+		expected = sha256.Sum256(data)
+		sum, err = b.Sum("sha256")
+		require.Nil(err)
+		require.Equal(expected[:], sum)
+	})
+
+	t.Run("ring mode", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.EnableRingMode(50))
+		require.Nil(b.AddHash("sha256", sha256.New()))
+		defer b.Close()
+
+		data := []byte(generateRandomString(100))
+		_, err := b.Write(data[:30])
+		require.Nil(err)
+		_, err = b.Write(data[30:])
+		require.Nil(err)
+
+		// All written bytes are hashed
+		expected := sha256.Sum256(data)
+		sum, err := b.Sum("sha256")
+		require.Nil(err)
+		require.Equal(expected[:], sum)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.EnableEncryption())
+		require.Nil(b.AddHash("sha256", sha256.New()))
+		defer b.Close()
+
+		data := []byte(generateRandomString(100))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		_, err = b.File()
+		require.Nil(err)
+
+		expected := sha256.Sum256(data)
+		sum, err := b.Sum("sha256")
+		require.Nil(err)
+		require.Equal(expected[:], sum)
+	})
+}
@@ -0,0 +1,285 @@
+package buffer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/minio/sio"
+	"github.com/pkg/errors"
+)
+
+// Spill file header format (all numbers are little-endian):
+//
+//	offset  size  field
+//	0       8     magic "GODSKBUF"
+//	8       2     format version (FileHeaderVersion)
+//	10      2     flags (FileFlags)
+//	12      1     cipher suite (CipherNone, CipherAES256GCM or CipherChaCha20Poly1305)
+//	13      3     reserved, must be zero
+//	16      16    key ID (see KeyID), zero for unencrypted files
+//	32      8     logical length: number of bytes of data before encryption
+//	40      4     CRC-32 (IEEE) of bytes [0, 40)
+//	44      4     reserved, must be zero
+//
+// The data follows the header. Encrypted data is stored in DARE 1.0 format (github.com/minio/sio),
+// package i is encrypted with sequence number i.
+
+const (
+	// FileHeaderSize is a size of the header of spill files
+	FileHeaderSize = 48
+	// FileHeaderVersion is the current version of the spill file format
+	FileHeaderVersion = 1
+
+	fileHeaderMagic = "GODSKBUF"
+	// fileHeaderChecksumOffset is an offset of the checksum of the header
+	fileHeaderChecksumOffset = 40
+)
+
+// FileFlags describe the data of a spill file
+type FileFlags uint16
+
+const (
+	// FileEncrypted means the data is encrypted
+	FileEncrypted FileFlags = 1 << iota
+	// FileCompressed means the data is compressed. It is reserved: Buffer doesn't compress data
+	FileCompressed
+	// FileChecksummed means the data has checksums. It is reserved: Buffer doesn't checksum data
+	FileChecksummed
+
+	knownFileFlags = FileEncrypted | FileCompressed | FileChecksummed
+)
+
+// Cipher suites of encrypted spill files
+const (
+	// CipherNone is used for unencrypted files
+	CipherNone byte = iota
+	// CipherAES256GCM is AES-256-GCM
+	CipherAES256GCM
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305
+	CipherChaCha20Poly1305
+)
+
+var (
+	// ErrInvalidFileHeader is used when a header of a spill file is corrupted or doesn't match the Buffer
+	ErrInvalidFileHeader = errors.New("invalid spill file header")
+
+	// ErrUnsupportedFileHeader is used when a spill file has an unknown version, flags or cipher suite
+	ErrUnsupportedFileHeader = errors.New("unsupported spill file header")
+)
+
+// FileHeader is a header of a spill file. It allows to identify a spill file left on a disk and to validate
+// it: to check the format, the length and, for encrypted files, whether the key matches (see KeyID).
+// The header doesn't allow to decrypt a file: the key exists only in the memory of the process. Files
+// returned by Buffer.File() and written by Buffer.CommitTo() don't have a header. Use Buffer.EnableFileHeader()
+// to write headers and ReadFileHeader() to read them
+type FileHeader struct {
+	Version     uint16
+	Flags       FileFlags
+	CipherSuite byte
+	// KeyID identifies the encryption key without revealing it (see KeyID)
+	KeyID [16]byte
+	// Length is a number of bytes of data before encryption. It is updated when writing is finished
+	// and after truncation. It is zero while the data is being written
+	Length int64
+}
+
+// KeyID returns an identifier of an encryption key: the first 16 bytes of HMAC-SHA256 of "go-disk-buffer key id"
+// with the key
+func KeyID(key []byte) [16]byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("go-disk-buffer key id"))
+
+	var id [16]byte
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+// ReadFileHeader reads and checks a header of a spill file. It returns ErrInvalidFileHeader if the header
+// is corrupted and ErrUnsupportedFileHeader if the version, the flags or the cipher suite are unknown
+func ReadFileHeader(r io.ReaderAt) (FileHeader, error) {
+	data := make([]byte, FileHeaderSize)
+	n, err := r.ReadAt(data, 0)
+	if n == len(data) {
+		// io.ReaderAt can return io.EOF with all bytes read
+		err = nil
+	}
+	if err == io.EOF {
+		return FileHeader{}, errors.Wrap(ErrInvalidFileHeader, "file is too short")
+	}
+	if err != nil {
+		return FileHeader{}, errors.Wrap(err, "can't read a file header")
+	}
+
+	return decodeFileHeader(data)
+}
+
+func decodeFileHeader(data []byte) (FileHeader, error) {
+	if !bytes.Equal(data[:len(fileHeaderMagic)], []byte(fileHeaderMagic)) {
+		return FileHeader{}, errors.Wrap(ErrInvalidFileHeader, "wrong magic bytes")
+	}
+	checksum := binary.LittleEndian.Uint32(data[fileHeaderChecksumOffset:])
+	if checksum != crc32.ChecksumIEEE(data[:fileHeaderChecksumOffset]) {
+		return FileHeader{}, errors.Wrap(ErrInvalidFileHeader, "wrong checksum")
+	}
+
+	h := FileHeader{
+		Version:     binary.LittleEndian.Uint16(data[8:]),
+		Flags:       FileFlags(binary.LittleEndian.Uint16(data[10:])),
+		CipherSuite: data[12],
+		Length:      int64(binary.LittleEndian.Uint64(data[32:])),
+	}
+	copy(h.KeyID[:], data[16:32])
+
+	encrypted := h.Flags&FileEncrypted != 0
+	switch {
+	case h.Version != FileHeaderVersion:
+		return h, errors.Wrapf(ErrUnsupportedFileHeader, "unknown version %d", h.Version)
+	case h.Flags&^knownFileFlags != 0:
+		return h, errors.Wrapf(ErrUnsupportedFileHeader, "unknown flags %#x", uint16(h.Flags&^knownFileFlags))
+	case h.CipherSuite > CipherChaCha20Poly1305:
+		return h, errors.Wrapf(ErrUnsupportedFileHeader, "unknown cipher suite %d", h.CipherSuite)
+	case encrypted && h.CipherSuite == CipherNone:
+		return h, errors.Wrap(ErrInvalidFileHeader, "no cipher suite for encrypted data")
+	case !encrypted && h.CipherSuite != CipherNone:
+		return h, errors.Wrap(ErrInvalidFileHeader, "cipher suite for unencrypted data")
+	case h.Length < 0:
+		return h, errors.Wrap(ErrInvalidFileHeader, "negative length")
+	}
+	return h, nil
+}
+
+func (h FileHeader) encode() []byte {
+	data := make([]byte, FileHeaderSize)
+	copy(data, fileHeaderMagic)
+	binary.LittleEndian.PutUint16(data[8:], h.Version)
+	binary.LittleEndian.PutUint16(data[10:], uint16(h.Flags))
+	data[12] = h.CipherSuite
+	copy(data[16:32], h.KeyID[:])
+	binary.LittleEndian.PutUint64(data[32:], uint64(h.Length))
+	binary.LittleEndian.PutUint32(data[fileHeaderChecksumOffset:], crc32.ChecksumIEEE(data[:fileHeaderChecksumOffset]))
+	return data
+}
+
+// EnableFileHeader makes Buffer write a header (see FileHeader) at the beginning of temp files created after
+// the call. The header is checked before reading. Note that plain files returned by Buffer.File() and files
+// saved by Buffer.CommitTo() don't have a header.
+//
+// File header can't be used in ring mode
+func (b *Buffer) EnableFileHeader() error {
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
+	}
+
+	b.fileHeader = true
+
+	return nil
+}
+
+// writeFileHeader writes the header of the temp file if needed. The current size of the file is used as the length
+func (b *Buffer) writeFileHeader() error {
+	if b.fileReader.headerSize == 0 || b.file.shared() {
+		// Readers have already checked the header. Buffer never reads more than its size
+		return nil
+	}
+
+	err := b.waitRateLimiter(FileHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	h := FileHeader{
+		Version: FileHeaderVersion,
+		Length:  b.fileSize,
+	}
+	if b.fileReader.encrypt {
+		h.Flags |= FileEncrypted
+		h.CipherSuite = fileCipherSuite(b.fileReader.cipherSuites[0])
+		h.KeyID = KeyID(b.fileReader.encryptionKey)
+	}
+
+	_, err = b.file.WriteAt(h.encode(), 0)
+	if err != nil {
+		return errors.Wrapf(err, "can't write a header of a temp file '%s'", b.filename)
+	}
+	// The header must be checked again
+	b.fileReader.headerChecked = false
+
+	return nil
+}
+
+// checkHeader reads and checks the header of the file once
+func (r *fileReader) checkHeader() error {
+	if r.headerSize == 0 || r.headerChecked {
+		return nil
+	}
+
+	err := r.wait(FileHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	h, err := ReadFileHeader(r.file)
+	if err == nil {
+		switch {
+		case h.Flags&(FileCompressed|FileChecksummed) != 0:
+			err = errors.Wrap(ErrUnsupportedFileHeader, "compressed and checksummed data isn't supported")
+		case (h.Flags&FileEncrypted != 0) != r.encrypt:
+			err = errors.Wrap(ErrInvalidFileHeader, "encryption flag doesn't match")
+		case r.encrypt && h.KeyID != KeyID(r.encryptionKey):
+			err = errors.Wrap(ErrInvalidFileHeader, "key ID doesn't match")
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't check a header of a temp file '%s'", r.file.Name())
+	}
+
+	if r.encrypt {
+		r.cipherSuites = []byte{sioCipherSuite(h.CipherSuite)}
+	}
+	r.length = h.Length
+	r.headerChecked = true
+
+	return nil
+}
+
+var (
+	defaultCipherSuiteOnce sync.Once
+	defaultCipherSuite     byte
+)
+
+// sioDefaultCipherSuite returns the cipher suite used by sio by default: AES-256-GCM if the CPU supports AES,
+// ChaCha20-Poly1305 otherwise
+func sioDefaultCipherSuite() byte {
+	defaultCipherSuiteOnce.Do(func() {
+		defaultCipherSuite = sio.AES_256_GCM
+
+		// Encrypt a single byte and check the cipher suite in the package header
+		var buf bytes.Buffer
+		_, err := sio.Encrypt(&buf, bytes.NewReader([]byte{0}), encryptionConfig(make([]byte, encryptionKeySize), 0, nil))
+		if err == nil && buf.Len() > 1 {
+			defaultCipherSuite = buf.Bytes()[1]
+		}
+	})
+	return defaultCipherSuite
+}
+
+// fileCipherSuite converts a sio cipher suite into a cipher suite of the file header
+func fileCipherSuite(suite byte) byte {
+	if suite == sio.CHACHA20_POLY1305 {
+		return CipherChaCha20Poly1305
+	}
+	return CipherAES256GCM
+}
+
+// sioCipherSuite converts a cipher suite of the file header into a sio cipher suite
+func sioCipherSuite(suite byte) byte {
+	if suite == CipherChaCha20Poly1305 {
+		return sio.CHACHA20_POLY1305
+	}
+	return sio.AES_256_GCM
+}
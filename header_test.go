@@ -0,0 +1,391 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_EnableFileHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+	}{
+		{name: "plain", encrypt: false},
+		{name: "encrypted", encrypt: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(10)
+			require.Nil(b.EnableFileHeader())
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			data := []byte(generateRandomString(200 << 10))
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			// The length is unknown while the data is being written
+			h, err := ReadFileHeader(b.file)
+			require.Nil(err)
+			require.Equal(int64(0), h.Length)
+
+			r, err := b.NewReader()
+			require.Nil(err)
+			defer r.Close()
+
+			h, err = ReadFileHeader(b.file)
+			require.Nil(err)
+			require.Equal(uint16(FileHeaderVersion), h.Version)
+			require.Equal(int64(len(data)-10), h.Length)
+			if tt.encrypt {
+				require.Equal(FileEncrypted, h.Flags)
+				require.NotEqual(CipherNone, h.CipherSuite)
+				require.Equal(KeyID(b.encryptionKey), h.KeyID)
+			} else {
+				require.Equal(FileFlags(0), h.Flags)
+				require.Equal(CipherNone, h.CipherSuite)
+				require.Equal([16]byte{}, h.KeyID)
+			}
+
+			info, err := os.Stat(b.filename)
+			require.Nil(err)
+			require.Equal(FileHeaderSize+b.physicalSize(h.Length), info.Size())
+
+			res, err := ioutil.ReadAll(r)
+			require.Nil(err)
+			require.Equal(data, res)
+
+			res, err = ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, res)
+		})
+	}
+
+	t.Run("truncate", func(t *testing.T) {
+		t.Parallel()
+
+		for _, encrypt := range []bool{false, true} {
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(10)
+			require.Nil(b.EnableFileHeader())
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+
+			data := []byte(generateRandomString(200 << 10))
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			res := make([]byte, 100)
+			_, err = io.ReadFull(b, res)
+			require.Nil(err)
+			require.Equal(data[:100], res)
+
+			require.Nil(b.Truncate(100 << 10))
+
+			h, err := ReadFileHeader(b.file)
+			require.Nil(err)
+			require.Equal(int64(100<<10+100-10), h.Length)
+
+			res, err = ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data[100:100<<10+100], res)
+
+			b.Close()
+		}
+	})
+
+	t.Run("write at", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.EnableFileHeader())
+		defer b.Close()
+
+		data := []byte(generateRandomString(1000))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		_, err = b.WriteAt([]byte("hello"), 500)
+		require.Nil(err)
+		copy(data[500:], "hello")
+
+		res, err := ioutil.ReadAll(b)
+		require.Nil(err)
+		require.Equal(data, res)
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.EnableFileHeader())
+		require.Nil(b.EnableEncryption())
+		b.EnableTempFileReuse()
+		defer b.Close()
+
+		for i := 0; i < 3; i++ {
+			data := []byte(generateRandomString(100 << 10))
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, res)
+
+			b.Reset()
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(0)
+		require.Nil(b.EnableFileHeader())
+		defer b.Close()
+
+		data := []byte(generateRandomString(1000))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		// Plain files don't have a header
+		file, err := b.File()
+		require.Nil(err)
+		defer file.Close()
+
+		res, err := ioutil.ReadAll(file)
+		require.Nil(err)
+		require.Equal(data, res)
+	})
+
+	t.Run("ring mode", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBuffer(nil)
+		require.Nil(b.EnableRingMode(100))
+		require.Equal(ErrRingModeUnsupported, b.EnableFileHeader())
+
+		b = NewBuffer(nil)
+		require.Nil(b.EnableFileHeader())
+		require.Equal(ErrRingModeUnsupported, b.EnableRingMode(100))
+	})
+}
+
+func TestBuffer_EnableFileHeader_InvalidHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		encrypt bool
+		change  func(header []byte)
+		wantErr error
+	}{
+		{
+			name: "wrong magic",
+			change: func(header []byte) {
+				header[0] = 'X'
+			},
+			wantErr: ErrInvalidFileHeader,
+		},
+		{
+			name: "wrong checksum",
+			change: func(header []byte) {
+				header[32]++
+			},
+			wantErr: ErrInvalidFileHeader,
+		},
+		{
+			name: "unknown version",
+			change: func(header []byte) {
+				h, _ := decodeFileHeader(header)
+				h.Version = FileHeaderVersion + 1
+				copy(header, h.encode())
+			},
+			wantErr: ErrUnsupportedFileHeader,
+		},
+		{
+			name: "compressed data",
+			change: func(header []byte) {
+				h, _ := decodeFileHeader(header)
+				h.Flags |= FileCompressed
+				copy(header, h.encode())
+			},
+			wantErr: ErrUnsupportedFileHeader,
+		},
+		{
+			name: "encrypted flag",
+			change: func(header []byte) {
+				h, _ := decodeFileHeader(header)
+				h.Flags |= FileEncrypted
+				h.CipherSuite = CipherAES256GCM
+				copy(header, h.encode())
+			},
+			wantErr: ErrInvalidFileHeader,
+		},
+		{
+			name:    "wrong key",
+			encrypt: true,
+			change: func(header []byte) {
+				h, _ := decodeFileHeader(header)
+				h.KeyID = KeyID([]byte("another key"))
+				copy(header, h.encode())
+			},
+			wantErr: ErrInvalidFileHeader,
+		},
+		{
+			name: "short length",
+			change: func(header []byte) {
+				h, _ := decodeFileHeader(header)
+				h.Length = 10
+				copy(header, h.encode())
+			},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(0)
+			require.Nil(b.EnableFileHeader())
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			_, err := b.Write([]byte(generateRandomString(1000)))
+			require.Nil(err)
+			require.Nil(b.finishWriting())
+
+			header := make([]byte, FileHeaderSize)
+			_, err = b.file.ReadAt(header, 0)
+			require.Nil(err)
+
+			tt.change(header)
+
+			_, err = b.file.WriteAt(header, 0)
+			require.Nil(err)
+
+			_, err = ioutil.ReadAll(b)
+			require.NotNil(err)
+			require.Equal(tt.wantErr, errors.Cause(err))
+		})
+	}
+}
+
+// failingHeaderFile fails WriteAt calls while fail is true
+type failingHeaderFile struct {
+	*os.File
+	fail *bool
+}
+
+func (f failingHeaderFile) WriteAt(data []byte, off int64) (int, error) {
+	if *f.fail {
+		return 0, errors.New("write error")
+	}
+	return f.File.WriteAt(data, off)
+}
+
+func TestBuffer_EnableFileHeader_WriteError(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	fail := true
+	b := NewBufferWithMaxMemorySize(0)
+	b.SetTempFileCreator(func(dir string) (TempFile, error) {
+		file, err := ioutil.TempFile(dir, "go-disk-buffer-*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		return failingHeaderFile{File: file, fail: &fail}, nil
+	})
+	require.Nil(b.ChangeTempDir(dir))
+	require.Nil(b.EnableFileHeader())
+	require.Nil(b.EnableEncryption())
+	defer b.Close()
+
+	_, err = b.Write([]byte("SECRET-PLAINTEXT-1"))
+	require.NotNil(err)
+	require.Nil(b.file)
+
+	// The broken file must be removed
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(err)
+	require.Empty(files)
+
+	// Data must never be written without encryption
+	_, err = b.Write([]byte("SECRET-PLAINTEXT-2"))
+	require.NotNil(err)
+	require.Nil(b.file)
+
+	fail = false
+	_, err = b.Write([]byte("SECRET-PLAINTEXT-3"))
+	require.Nil(err)
+	require.Nil(b.finishWriting())
+
+	content, err := ioutil.ReadFile(b.filename)
+	require.Nil(err)
+	require.False(bytes.Contains(content, []byte("SECRET-PLAINTEXT")), "data must be encrypted")
+
+	res, err := ioutil.ReadAll(b)
+	require.Nil(err)
+	require.Equal("SECRET-PLAINTEXT-3", string(res))
+}
+
+func TestReadFileHeader(t *testing.T) {
+	require := require.New(t)
+
+	h := FileHeader{
+		Version:     FileHeaderVersion,
+		Flags:       FileEncrypted,
+		CipherSuite: CipherChaCha20Poly1305,
+		KeyID:       KeyID([]byte("key")),
+		Length:      1 << 40,
+	}
+	data := h.encode()
+	require.Len(data, FileHeaderSize)
+	require.Equal([]byte("GODSKBUF"), data[:8])
+
+	res, err := ReadFileHeader(bytes.NewReader(data))
+	require.Nil(err)
+	require.Equal(h, res)
+
+	_, err = ReadFileHeader(bytes.NewReader(data[:FileHeaderSize-1]))
+	require.Equal(ErrInvalidFileHeader, errors.Cause(err))
+
+	// Cipher suite must match the encryption flag
+	h.Flags = 0
+	_, err = ReadFileHeader(bytes.NewReader(h.encode()))
+	require.Equal(ErrInvalidFileHeader, errors.Cause(err))
+
+	h.Flags = FileEncrypted
+	h.CipherSuite = 10
+	_, err = ReadFileHeader(bytes.NewReader(h.encode()))
+	require.Equal(ErrUnsupportedFileHeader, errors.Cause(err))
+}
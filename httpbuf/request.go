@@ -0,0 +1,177 @@
+// Package httpbuf helps to buffer bodies of HTTP requests and responses with buffer.Buffer.
+// Small bodies are stored in memory, huge ones – in temp files
+package httpbuf
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+var (
+	// ErrBodyTooLarge is used when a body is larger than Options.MaxBodySize
+	ErrBodyTooLarge = errors.New("body is too large")
+
+	// ErrBodyReleased is used when a body is requested after the release
+	ErrBodyReleased = errors.New("body is released")
+)
+
+// Options are used to configure buffers
+type Options struct {
+	// MaxMemorySize is a max number of bytes stored in memory. buffer.DefaultMaxMemorySize is used by default
+	MaxMemorySize int
+	// MaxBodySize is a max size of a body. Zero means no limit
+	MaxBodySize int64
+	// TempDir is a directory for temp files. os.TempDir() is used by default
+	TempDir string
+	// Encrypt enables encryption of temp files
+	Encrypt bool
+}
+
+func (opts Options) newBuffer() (*buffer.Buffer, error) {
+	maxMemorySize := opts.MaxMemorySize
+	if maxMemorySize == 0 {
+		maxMemorySize = buffer.DefaultMaxMemorySize
+	}
+
+	b := buffer.NewBufferWithMaxMemorySize(maxMemorySize)
+	if opts.TempDir != "" {
+		err := b.ChangeTempDir(opts.TempDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Encrypt {
+		err := b.EnableEncryption()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// readAll reads r into a new Buffer. It returns ErrBodyTooLarge if r contains more than opts.MaxBodySize bytes
+func (opts Options) readAll(req *http.Request, r io.Reader) (*buffer.Buffer, error) {
+	b, err := opts.newBuffer()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ContentLength > 0 && (opts.MaxBodySize <= 0 || req.ContentLength <= opts.MaxBodySize) {
+		// Choose the storage in advance: large bodies are written directly into a temp file
+		err = b.SizeHint(req.ContentLength)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+
+	if opts.MaxBodySize > 0 {
+		// Read an extra byte to find out whether the body is too large
+		r = io.LimitReader(r, opts.MaxBodySize+1)
+	}
+
+	_, err = b.ReadFromContext(req.Context(), r, buffer.WithCleanUpOnAbort())
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxBodySize > 0 && b.Remaining() > opts.MaxBodySize {
+		b.Close()
+		return nil, ErrBodyTooLarge
+	}
+
+	return b, nil
+}
+
+// bufferedBody creates Readers of a buffered body. Buffer isn't thread-safe, so we need a mutex:
+// http.Transport can call http.Request.GetBody in its own goroutine
+type bufferedBody struct {
+	mu       sync.Mutex
+	buf      *buffer.Buffer
+	released bool
+}
+
+func (b *bufferedBody) newReader() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.released {
+		return nil, ErrBodyReleased
+	}
+	return b.buf.NewReader()
+}
+
+func (b *bufferedBody) release() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.released {
+		return nil
+	}
+	b.released = true
+
+	return b.buf.Close()
+}
+
+// BufferRequest reads the body of the request into a Buffer and replaces req.Body with a Reader
+// of this Buffer. It also sets req.ContentLength and req.GetBody, so the request can be retried
+// or redirected by http.Client.
+//
+// The returned release function must be called after the request is finished. The temp file
+// is removed when release is called and all bodies are closed
+func BufferRequest(req *http.Request, opts Options) (release func() error, err error) {
+	release = func() error { return nil }
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return release, nil
+	}
+
+	b, err := opts.readAll(req, req.Body)
+	req.Body.Close()
+	if err != nil {
+		return release, err
+	}
+
+	body := &bufferedBody{buf: b}
+
+	r, err := body.newReader()
+	if err != nil {
+		body.release()
+		return release, err
+	}
+
+	req.Body = r
+	req.ContentLength = b.Remaining()
+	req.GetBody = body.newReader
+
+	return body.release, nil
+}
+
+// Middleware buffers bodies of incoming requests before calling the next handler. Temp files are
+// removed after the handler returns. Middleware responds with 413 Request Entity Too Large if
+// a body is larger than opts.MaxBodySize and with 400 Bad Request if a body can't be read
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			release, err := BufferRequest(r, opts)
+			defer release()
+
+			switch {
+			case err == ErrBodyTooLarge:
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				http.Error(w, "can't read the body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer r.Body.Close()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
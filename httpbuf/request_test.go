@@ -0,0 +1,169 @@
+package httpbuf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func generateRandomBody(size int) []byte {
+	body := make([]byte, size)
+	rand.Read(body)
+	return body
+}
+
+func TestBufferRequest(t *testing.T) {
+	tests := []struct {
+		desc     string
+		opts     Options
+		bodySize int
+		err      error
+	}{
+		{desc: "memory", opts: Options{MaxMemorySize: 1 << 10}, bodySize: 100},
+		{desc: "file", opts: Options{MaxMemorySize: 1 << 10}, bodySize: 10 << 10},
+		{desc: "encrypted file", opts: Options{MaxMemorySize: 1 << 10, Encrypt: true}, bodySize: 100 << 10},
+		{desc: "max size", opts: Options{MaxMemorySize: 1 << 10, MaxBodySize: 10 << 10}, bodySize: 10 << 10},
+		{desc: "too large", opts: Options{MaxMemorySize: 1 << 10, MaxBodySize: 10 << 10}, bodySize: 10<<10 + 1, err: ErrBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			body := generateRandomBody(tt.bodySize)
+
+			req := httptest.NewRequest("POST", "/", ioutil.NopCloser(bytes.NewReader(body)))
+			req.ContentLength = -1
+
+			release, err := BufferRequest(req, tt.opts)
+			defer release()
+
+			require.Equal(tt.err, err)
+			if err != nil {
+				return
+			}
+
+			require.Equal(int64(len(body)), req.ContentLength)
+
+			res, err := ioutil.ReadAll(req.Body)
+			require.Nil(err)
+			require.Equal(body, res)
+			require.Nil(req.Body.Close())
+
+			// The body can be read again
+			for i := 0; i < 2; i++ {
+				newBody, err := req.GetBody()
+				require.Nil(err)
+
+				res, err := ioutil.ReadAll(newBody)
+				require.Nil(err)
+				require.Equal(body, res)
+				require.Nil(newBody.Close())
+			}
+
+			require.Nil(release())
+
+			_, err = req.GetBody()
+			require.Equal(ErrBodyReleased, err)
+		})
+	}
+
+	t.Run("no body", func(t *testing.T) {
+		require := require.New(t)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Body = nil
+
+		release, err := BufferRequest(req, Options{})
+		require.Nil(err)
+		require.Nil(release())
+		require.Nil(req.Body)
+	})
+}
+
+func TestBufferRequest_Redirect(t *testing.T) {
+	require := require.New(t)
+
+	body := generateRandomBody(100 << 10)
+
+	var received [][]byte
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received = append(received, data)
+
+		http.Redirect(w, r, "/target", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		received = append(received, data)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, err := http.NewRequest("POST", server.URL+"/redirect", ioutil.NopCloser(bytes.NewReader(body)))
+	require.Nil(err)
+
+	release, err := BufferRequest(req, Options{MaxMemorySize: 1 << 10})
+	require.Nil(err)
+	defer release()
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(err)
+	resp.Body.Close()
+
+	require.Equal(http.StatusOK, resp.StatusCode)
+	require.Len(received, 2)
+	require.Equal(body, received[0])
+	require.Equal(body, received[1])
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		desc   string
+		body   string
+		status int
+	}{
+		{desc: "ok", body: "hello", status: http.StatusOK},
+		{desc: "too large", body: strings.Repeat("hello", 100), status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			handler := Middleware(Options{MaxMemorySize: 10, MaxBodySize: 100})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(int64(len(tt.body)), r.ContentLength)
+
+				data, err := ioutil.ReadAll(r.Body)
+				require.Nil(err)
+				w.Write(data)
+			}))
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				require.Equal(tt.body, w.Body.String())
+			}
+		})
+	}
+}
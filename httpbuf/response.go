@@ -0,0 +1,132 @@
+package httpbuf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	buffer "github.com/ShoshinNikita/go-disk-buffer"
+)
+
+// etagHash is a name of the hash used to compute ETag
+const etagHash = "sha256"
+
+// responseWriter writes a response into a Buffer
+type responseWriter struct {
+	w http.ResponseWriter
+
+	maxBodySize int64
+
+	status int
+	buf    *buffer.Buffer
+	err    error
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *responseWriter) Write(data []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if rw.maxBodySize > 0 && rw.buf.Remaining()+int64(len(data)) > rw.maxBodySize {
+		rw.err = ErrBodyTooLarge
+		return 0, rw.err
+	}
+
+	n, err := rw.buf.Write(data)
+	if err != nil {
+		rw.err = err
+	}
+	return n, err
+}
+
+// etag returns a strong ETag computed from the hash of the response
+func (rw *responseWriter) etag() (string, error) {
+	sum, err := rw.buf.Sum(etagHash)
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(sum) + `"`, nil
+}
+
+// ResponseMiddleware buffers responses of the next handler. Data that doesn't fit in memory is stored
+// in a temp file. When the handler returns, ResponseMiddleware sets Content-Length header and ETag header
+// (SHA-256 of the response, if the handler hasn't set it) and sends the response. 200 OK responses
+// are sent with http.ServeContent, so Range and conditional requests are supported.
+//
+// ResponseMiddleware responds with 500 Internal Server Error if the response can't be buffered or
+// is larger than opts.MaxBodySize. Temp files are removed after the response is sent
+func ResponseMiddleware(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := opts.newBuffer()
+			if err != nil {
+				http.Error(w, "can't create a buffer: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer b.Close()
+
+			err = b.AddHash(etagHash, sha256.New())
+			if err != nil {
+				http.Error(w, "can't create a buffer: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			rw := &responseWriter{
+				w:           w,
+				maxBodySize: opts.MaxBodySize,
+				buf:         b,
+			}
+			next.ServeHTTP(rw, r)
+
+			if rw.err != nil {
+				http.Error(w, "can't buffer the response: "+rw.err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+
+			body, err := b.NewReader()
+			if err != nil {
+				http.Error(w, "can't read the response: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer body.Close()
+
+			if rw.status == http.StatusOK {
+				if w.Header().Get("ETag") == "" {
+					etag, err := rw.etag()
+					if err != nil {
+						http.Error(w, "can't compute ETag: "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("ETag", etag)
+				}
+
+				// http.ServeContent sets Content-Length and handles Range and conditional requests
+				http.ServeContent(w, r, "", time.Time{}, body)
+				return
+			}
+
+			w.Header().Set("Content-Length", strconv.FormatInt(body.Size(), 10))
+			w.WriteHeader(rw.status)
+			if r.Method != http.MethodHead {
+				io.Copy(w, body)
+			}
+		})
+	}
+}
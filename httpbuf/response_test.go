@@ -0,0 +1,94 @@
+package httpbuf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseMiddleware(t *testing.T) {
+	body := generateRandomBody(100 << 10)
+
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+
+	handler := ResponseMiddleware(Options{MaxMemorySize: 1 << 10, MaxBodySize: 1 << 20})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/not-found":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		case "/too-large":
+			w.Write(generateRandomBody(1<<20 + 1))
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			// Write by chunks
+			for i := 0; i < len(body); i += 1000 {
+				end := i + 1000
+				if end > len(body) {
+					end = len(body)
+				}
+				w.Write(body[i:end])
+			}
+		}
+	}))
+
+	tests := []struct {
+		desc   string
+		method string
+		path   string
+		header map[string]string
+		//
+		status        int
+		body          []byte
+		contentLength int
+	}{
+		{desc: "ok", method: "GET", path: "/", status: http.StatusOK, body: body, contentLength: len(body)},
+		{desc: "head", method: "HEAD", path: "/", status: http.StatusOK, body: []byte{}, contentLength: len(body)},
+		{
+			desc: "range", method: "GET", path: "/", header: map[string]string{"Range": "bytes=50000-50999"},
+			status: http.StatusPartialContent, body: body[50000:51000], contentLength: 1000,
+		},
+		{
+			desc: "if-none-match", method: "GET", path: "/", header: map[string]string{"If-None-Match": etag},
+			status: http.StatusNotModified, body: []byte{},
+		},
+		{desc: "not found", method: "GET", path: "/not-found", status: http.StatusNotFound, body: []byte("not found"), contentLength: 9},
+		{desc: "too large", method: "GET", path: "/too-large", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(tt.status, w.Code)
+			if tt.status == http.StatusInternalServerError {
+				return
+			}
+
+			require.Equal(string(tt.body), w.Body.String())
+			if tt.contentLength != 0 {
+				require.Equal(strconv.Itoa(tt.contentLength), w.Header().Get("Content-Length"))
+			}
+			if tt.path == "/" {
+				require.Equal(etag, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package buffer
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMemoryLockUnsupported is used when memory can't be locked on the current platform
+	ErrMemoryLockUnsupported = errors.New("memory lock is supported only on linux")
+
+	// ErrMemoryLockLimit is used when memory can't be locked because of RLIMIT_MEMLOCK
+	ErrMemoryLockLimit = errors.New("RLIMIT_MEMLOCK is too low")
+)
+
+// EnableMemoryLock allocates the memory for maxInMemorySize bytes and the encryption key with mmap and locks
+// them into RAM with mlock, so they are never swapped to disk. The memory is also excluded from core dumps
+// (MADV_DONTDUMP). The locked memory is zeroed and unlocked on Buffer.Close(). Readers returned
+// by Buffer.NewReader() get their own copy of the memory and the key, which is also locked.
+//
+// If encryption is enabled, the last decrypted package of the temp file (64 KB) is cached in locked memory
+// too. Note that the encryption stream (github.com/minio/sio) keeps up to 64 KB of data that isn't encrypted
+// yet in regular memory while writing.
+//
+// Memory lock is supported only on Linux (ErrMemoryLockUnsupported is returned on other platforms). If the memory
+// can't be locked, for example, when RLIMIT_MEMLOCK is too low (ErrMemoryLockLimit), an error is returned.
+// If allowFallback is true, regular memory is used instead and nil is returned. Use Buffer.MemoryLocked()
+// to check whether the memory is locked.
+//
+// Memory lock must be enabled before writing. It can't be used in ring mode. Memory lock is disabled
+// on Buffer.Close(), so EnableMemoryLock must be called again to reuse the Buffer
+func (b *Buffer) EnableMemoryLock(allowFallback bool) error {
+	if b.ringLimit != 0 {
+		return ErrRingModeUnsupported
+	}
+	if b.size != 0 {
+		return errors.New("memory lock must be enabled before writing")
+	}
+	if b.lockedMemory != nil {
+		return nil
+	}
+
+	mem, err := lockMemory(encryptionKeySize + b.maxInMemorySize)
+	if err != nil {
+		if allowFallback {
+			return nil
+		}
+		return err
+	}
+
+	// Move the key into the locked memory
+	key := mem.data[:encryptionKeySize:encryptionKeySize]
+	if b.encryptionKey != nil {
+		copy(key, b.encryptionKey)
+		wipe(b.encryptionKey)
+	}
+	b.encryptionKey = key
+	if b.fileReader.encryptionKey != nil {
+		b.fileReader.encryptionKey = key
+	}
+
+	// Replace the memory. It is empty, so nothing must be copied
+	b.replaceMemory(0)
+	b.buff = *bytes.NewBuffer(mem.data[encryptionKeySize:encryptionKeySize])
+	b.lockedMemory = mem
+
+	return nil
+}
+
+// MemoryLocked reports whether the memory and the encryption key are locked into RAM
+func (b *Buffer) MemoryLocked() bool {
+	return b.lockedMemory != nil
+}
+
+// unlockMemory zeroes and releases the locked memory. The Buffer uses regular memory after the call
+func (b *Buffer) unlockMemory() error {
+	if b.lockedMemory == nil {
+		return nil
+	}
+
+	b.buff = bytes.Buffer{}
+	b.encryptionKey = nil
+	b.encryptionKeyWiped = true
+	b.fileReader.encryptionKey = nil
+	b.fileReader.packageBuffer = nil
+	b.fileReader.resetCache()
+
+	err := b.lockedMemory.release()
+	b.lockedMemory = nil
+	if b.lockedPackage != nil {
+		if releaseErr := b.lockedPackage.release(); err == nil {
+			err = releaseErr
+		}
+		b.lockedPackage = nil
+	}
+	return err
+}
+
+// lockedMemory is memory that is locked into RAM
+type lockedMemory struct {
+	data []byte
+}
+
+// release zeroes and unlocks the memory. The memory mustn't be used after the call
+func (m *lockedMemory) release() error {
+	wipe(m.data)
+
+	err := unlockMemory(m.data)
+	m.data = nil
+	if err != nil {
+		return errors.Wrap(err, "can't unlock memory")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package buffer
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// madvDontDump excludes pages from core dumps. It isn't defined in package syscall
+const madvDontDump = 0x10
+
+// lockMemory allocates size bytes with mmap and locks them with mlock
+func lockMemory(size int) (*lockedMemory, error) {
+	data, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't allocate %d bytes", size)
+	}
+
+	err = syscall.Mlock(data)
+	if err != nil {
+		syscall.Munmap(data)
+
+		if err == syscall.ENOMEM || err == syscall.EPERM || err == syscall.EAGAIN {
+			return nil, errors.Wrapf(ErrMemoryLockLimit, "can't lock %d bytes (%s)", size, err)
+		}
+		return nil, errors.Wrapf(err, "can't lock %d bytes", size)
+	}
+
+	// Old kernels don't support MADV_DONTDUMP. The memory is locked anyway, so the error is ignored
+	_ = syscall.Madvise(data, madvDontDump)
+
+	return &lockedMemory{data: data}, nil
+}
+
+// unlockMemory unlocks and frees memory allocated by lockMemory
+func unlockMemory(data []byte) error {
+	err := syscall.Munlock(data)
+	if unmapErr := syscall.Munmap(data); err == nil {
+		err = unmapErr
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package buffer
+
+func lockMemory(size int) (*lockedMemory, error) {
+	return nil, ErrMemoryLockUnsupported
+}
+
+func unlockMemory(data []byte) error {
+	return nil
+}
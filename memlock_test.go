@@ -0,0 +1,190 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// enableMemoryLock enables memory lock or skips the test if the memory can't be locked
+func enableMemoryLock(t *testing.T, b *Buffer) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory lock is supported only on linux")
+	}
+
+	err := b.EnableMemoryLock(false)
+	if errors.Cause(err) == ErrMemoryLockLimit {
+		t.Skip("RLIMIT_MEMLOCK is too low")
+	}
+	require.Nil(t, err)
+}
+
+func TestBuffer_EnableMemoryLock(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+
+		name := "plain"
+		if encrypt {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(100)
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			enableMemoryLock(t, b)
+			defer b.Close()
+
+			require.True(b.MemoryLocked())
+			require.Equal(100, b.buff.Cap())
+
+			data := []byte(generateRandomString(1000))
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			// Data and the key must be stored in the locked memory
+			memory := b.lockedMemory.data
+			require.Equal(data[:100], memory[encryptionKeySize:])
+			if encrypt {
+				require.Equal(b.encryptionKey, memory[:encryptionKeySize])
+				require.False(isZeroed(b.encryptionKey))
+			}
+
+			r, err := b.NewReader()
+			require.Nil(err)
+			require.NotNil(r.lockedMemory)
+
+			res, err := ioutil.ReadAll(r)
+			require.Nil(err)
+			require.Equal(data, res)
+			require.Nil(r.Close())
+			require.Nil(r.lockedMemory)
+
+			res = readByChunks(require, b, 30)
+			require.Equal(data, res)
+
+			require.Nil(b.Close())
+			require.False(b.MemoryLocked())
+
+			// Buffer can be used after Close with regular memory
+			_, err = b.Write(data)
+			require.Nil(err)
+			res = readByChunks(require, b, 30)
+			require.Equal(data, res)
+		})
+	}
+
+	t.Run("size hint", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		enableMemoryLock(t, b)
+		defer b.Close()
+
+		// Locked memory isn't replaced
+		require.Nil(b.SizeHint(10))
+		require.Equal(100, b.buff.Cap())
+
+		data := []byte(generateRandomString(50))
+		_, err := b.Write(data)
+		require.Nil(err)
+		require.Nil(b.file)
+		require.Equal(data, b.lockedMemory.data[encryptionKeySize:encryptionKeySize+50])
+	})
+
+	t.Run("decrypted package", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		require.Nil(b.EnableEncryption())
+		enableMemoryLock(t, b)
+		defer b.Close()
+
+		data := []byte(generateRandomString(200 << 10))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		// The last package is decrypted and encrypted again
+		require.Nil(b.Truncate(150 << 10))
+		data = data[:150<<10]
+		require.NotNil(b.lockedPackage)
+
+		// Decrypted packages must be stored in the locked memory
+		r, err := b.NewReader()
+		require.Nil(err)
+
+		res, err := ioutil.ReadAll(r)
+		require.Nil(err)
+		require.Equal(data, res)
+		require.True(&r.file.decryptedPackage[0] == &r.lockedMemory.data[encryptionKeySize])
+		require.Nil(r.Close())
+
+		res = make([]byte, 100<<10)
+		_, err = io.ReadFull(b, res)
+		require.Nil(err)
+		require.Equal(data[:100<<10], res)
+		require.True(&b.fileReader.decryptedPackage[0] == &b.lockedPackage.data[0])
+
+		res, err = ioutil.ReadAll(b)
+		require.Nil(err)
+		require.Equal(data[100<<10:], res)
+
+		require.Nil(b.Close())
+		require.Nil(b.lockedPackage)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		defer b.Close()
+
+		_, err := b.Write([]byte("hello"))
+		require.Nil(err)
+		require.NotNil(b.EnableMemoryLock(true))
+
+		b = NewBufferWithMaxMemorySize(100)
+		defer b.Close()
+
+		require.Nil(b.EnableRingMode(50))
+		require.Equal(ErrRingModeUnsupported, b.EnableMemoryLock(true))
+
+		b = NewBufferWithMaxMemorySize(100)
+		enableMemoryLock(t, b)
+		defer b.Close()
+
+		require.Equal(ErrRingModeUnsupported, b.EnableRingMode(50))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if runtime.GOOS == "linux" {
+			t.Skip("memory lock is supported on linux")
+		}
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		defer b.Close()
+
+		require.Equal(ErrMemoryLockUnsupported, errors.Cause(b.EnableMemoryLock(false)))
+
+		// Fallback to regular memory
+		require.Nil(b.EnableMemoryLock(true))
+		require.False(b.MemoryLocked())
+	})
+}
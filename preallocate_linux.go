@@ -0,0 +1,28 @@
+package buffer
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE flag. The size of the file isn't changed
+const fallocKeepSize = 0x01
+
+// preallocate allocates disk space for the range [off, off+size) of the file
+func preallocate(file *os.File, off, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	for {
+		err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, off, size)
+		switch err {
+		case syscall.EINTR:
+			continue
+		case syscall.EOPNOTSUPP, syscall.ENOSYS:
+			// The filesystem doesn't support preallocation
+			return nil
+		}
+		return err
+	}
+}
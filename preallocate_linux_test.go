@@ -0,0 +1,84 @@
+package buffer
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_SizeHint_Preallocate(t *testing.T) {
+	const hint = 1 << 20
+
+	checkFile := func(t *testing.T, b *Buffer, size int64) {
+		var stat syscall.Stat_t
+		require.Nil(t, syscall.Fstat(int(b.file.TempFile.(*os.File).Fd()), &stat))
+		require.Equal(t, size, stat.Size, "size of the file must not be changed")
+		if stat.Blocks*512 < hint-10 {
+			t.Skip("filesystem doesn't support preallocation")
+		}
+	}
+
+	t.Run("skip memory", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		require.Nil(b.SizeHint(hint))
+		require.NotNil(b.file)
+
+		_, err := b.Write(make([]byte, 20))
+		require.Nil(err)
+		require.Equal(0, b.buff.Len())
+		checkFile(t, b, 20)
+	})
+
+	t.Run("preallocate on spill", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		_, err := b.Write(make([]byte, 5))
+		require.Nil(err)
+
+		require.Nil(b.SizeHint(hint))
+		require.Nil(b.file)
+
+		_, err = b.Write(make([]byte, 20))
+		require.Nil(err)
+		require.Equal(10, b.buff.Len())
+		checkFile(t, b, 15)
+	})
+
+	t.Run("no space", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		err := b.SizeHint(1 << 60)
+		if err == nil {
+			t.Skip("filesystem doesn't support preallocation")
+		}
+		require.Nil(b.file, "the file must be removed")
+
+		b.Reset()
+		_, err = b.Write(make([]byte, 5))
+		require.Nil(err)
+		require.Nil(b.SizeHint(1 << 60))
+
+		n, err := b.Write(make([]byte, 20))
+		require.NotNil(err)
+		require.Equal(5, n)
+		require.Nil(b.file, "the file must be removed")
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package buffer
+
+import (
+	"os"
+)
+
+// preallocate does nothing: preallocation is supported only on Linux
+func preallocate(file *os.File, off, size int64) error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package buffer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidRate is used when NewRateLimiter() is called with a non-positive rate
+	ErrInvalidRate = errors.New("rate must be greater than zero")
+)
+
+// RateLimiter limits the rate of disk I/O with a token bucket: every read or written byte takes a token.
+// If there are not enough tokens, the operation blocks. RateLimiter is thread-safe, so it can be shared
+// by several Buffers
+type RateLimiter struct {
+	mu sync.Mutex
+
+	// rate is a number of tokens added per second
+	rate float64
+	// burst is a max number of tokens
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	waited time.Duration
+}
+
+// NewRateLimiter creates a new RateLimiter that allows bytesPerSecond bytes per second with bursts
+// of at most burst bytes. If burst <= 0, bytesPerSecond is used. NewRateLimiter returns ErrInvalidRate
+// if bytesPerSecond <= 0. Use Buffer.SetRateLimiter(nil) to disable rate limiting
+func NewRateLimiter(bytesPerSecond, burst int) (*RateLimiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, ErrInvalidRate
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// WaitN blocks until n bytes can be read or written. It returns the wait time. If ctx is done before
+// the end of the wait, WaitN returns ctx.Err() and the tokens are returned to the bucket
+func (l *RateLimiter) WaitN(ctx context.Context, n int) (time.Duration, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	l.refill(time.Now())
+	// Tokens can be negative: the next operations will wait longer
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		l.addWaited(wait)
+		return wait, nil
+
+	case <-ctx.Done():
+		wait = time.Since(start)
+
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.refill(time.Now())
+		l.waited += wait
+		l.mu.Unlock()
+
+		return wait, ctx.Err()
+	}
+}
+
+// Waited returns the total time spent waiting for tokens
+func (l *RateLimiter) Waited() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.waited
+}
+
+func (l *RateLimiter) addWaited(wait time.Duration) {
+	l.mu.Lock()
+	l.waited += wait
+	l.mu.Unlock()
+}
+
+// refill adds tokens for the time passed since the last refill. It must be called under the mutex
+func (l *RateLimiter) refill(now time.Time) {
+	if now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		l.last = now
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// SetRateLimiter sets a limiter for reading and writing of the temp file. The limiter can be shared
+// by several Buffers. File headers and copies made by Buffer.File() and Buffer.CommitTo() are limited
+// too. Buffer.ReadFromContext() and Buffer.WriteToContext() stop waiting when their context is done.
+// Pass nil to disable rate limiting
+func (b *Buffer) SetRateLimiter(l *RateLimiter) {
+	b.rateLimiter = l
+}
+
+// RateLimitWait returns the total time the Buffer spent waiting for the rate limiter
+func (b *Buffer) RateLimitWait() time.Duration {
+	return b.rateLimitWait
+}
+
+// waitRateLimiter blocks until n bytes can be read from the file or written into the file
+func (b *Buffer) waitRateLimiter(n int) error {
+	if b.rateLimiter == nil {
+		return nil
+	}
+
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	wait, err := b.rateLimiter.WaitN(ctx, n)
+	b.rateLimitWait += wait
+	return err
+}
+
+// newRateLimiterWait returns a function that blocks until n bytes can be read. It is used by Readers
+func newRateLimiterWait(l *RateLimiter) func(n int) error {
+	if l == nil {
+		return nil
+	}
+
+	return func(n int) error {
+		_, err := l.WaitN(context.Background(), n)
+		return err
+	}
+}
+
+// rateLimitedReader calls wait after every read. It is used to copy files
+type rateLimitedReader struct {
+	r    io.Reader
+	wait func(n int) error
+}
+
+func (r rateLimitedReader) Read(data []byte) (int, error) {
+	n, err := r.r.Read(data)
+	if n > 0 {
+		if waitErr := r.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// rateLimitedWriter calls wait before every write. It is used to copy files
+type rateLimitedWriter struct {
+	w    io.Writer
+	wait func(n int) error
+}
+
+func (w rateLimitedWriter) Write(data []byte) (int, error) {
+	err := w.wait(len(data))
+	if err != nil {
+		return 0, err
+	}
+	return w.w.Write(data)
+}
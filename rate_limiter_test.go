@@ -0,0 +1,209 @@
+package buffer
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newRateLimiter(t *testing.T, bytesPerSecond, burst int) *RateLimiter {
+	l, err := NewRateLimiter(bytesPerSecond, burst)
+	require.Nil(t, err)
+	return l
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("wait", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		l := newRateLimiter(t, 1000, 100)
+
+		wait, err := l.WaitN(context.Background(), 100)
+		require.Nil(err)
+		require.Equal(time.Duration(0), wait, "burst must be available")
+
+		wait, err = l.WaitN(context.Background(), 100)
+		require.Nil(err)
+		require.True(wait > 50*time.Millisecond, "wait: %s", wait)
+		require.Equal(wait, l.Waited())
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		l := newRateLimiter(t, 10, 10)
+
+		_, err := l.WaitN(context.Background(), 10)
+		require.Nil(err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err = l.WaitN(ctx, 100)
+		require.Equal(context.DeadlineExceeded, err)
+		require.True(time.Since(start) < time.Second)
+		require.True(l.Waited() > 0)
+	})
+
+	t.Run("invalid rate", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		_, err := NewRateLimiter(0, 10)
+		require.Equal(ErrInvalidRate, err)
+		_, err = NewRateLimiter(-1, 10)
+		require.Equal(ErrInvalidRate, err)
+	})
+}
+
+func TestBuffer_SetRateLimiter(t *testing.T) {
+	t.Run("write and read", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		for _, encrypt := range []bool{false, true} {
+			b := NewBufferWithMaxMemorySize(1 << 10)
+			b.SetRateLimiter(newRateLimiter(t, 200<<10, 10<<10))
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+
+			data := generateRandomString(20 << 10)
+			_, err := b.WriteString(data)
+			require.Nil(err)
+			require.True(b.RateLimitWait() > 0, "write must wait")
+
+			wait := b.RateLimitWait()
+			res, err := ioutil.ReadAll(b)
+			require.Nil(err)
+			require.Equal(data, string(res))
+			require.True(b.RateLimitWait() > wait, "read must wait")
+
+			require.Nil(b.Close())
+		}
+	})
+
+	t.Run("shared limiter", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		l := newRateLimiter(t, 200<<10, 10<<10)
+
+		b1 := NewBufferWithMaxMemorySize(0)
+		b1.SetRateLimiter(l)
+		defer b1.Close()
+
+		b2 := NewBufferWithMaxMemorySize(0)
+		b2.SetRateLimiter(l)
+		defer b2.Close()
+
+		_, err := b1.WriteString(generateRandomString(10 << 10))
+		require.Nil(err)
+		require.Equal(time.Duration(0), b1.RateLimitWait(), "burst must be available")
+
+		_, err = b2.WriteString(generateRandomString(10 << 10))
+		require.Nil(err)
+		require.True(b2.RateLimitWait() > 0, "the second buffer must wait")
+		require.Equal(b1.RateLimitWait()+b2.RateLimitWait(), l.Waited())
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		l := newRateLimiter(t, 200<<10, 10<<10)
+
+		b := NewBufferWithMaxMemorySize(0)
+		defer b.Close()
+
+		data := generateRandomString(20 << 10)
+		_, err := b.WriteString(data)
+		require.Nil(err)
+
+		b.SetRateLimiter(l)
+		r, err := b.NewReader()
+		require.Nil(err)
+		defer r.Close()
+
+		res, err := ioutil.ReadAll(r)
+		require.Nil(err)
+		require.Equal(data, string(res))
+		require.True(l.Waited() > 0)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		defer b.Close()
+
+		data := generateRandomString(50 << 10)
+		_, err := b.WriteString(data)
+		require.Nil(err)
+
+		// The data is copied into a new file: both reads and writes must be limited
+		b.SetRateLimiter(newRateLimiter(t, 1<<20, 1<<10))
+		file, err := b.File()
+		require.Nil(err)
+		defer file.Close()
+		require.True(b.RateLimitWait() > 50*time.Millisecond)
+
+		res, err := ioutil.ReadAll(file)
+		require.Nil(err)
+		require.Equal(data, string(res))
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(0)
+		b.SetRateLimiter(newRateLimiter(t, 100<<10, 1<<10))
+		defer b.Close()
+
+		var progress Progress
+		_, err := b.ReadFromContext(context.Background(), strings.NewReader(generateRandomString(5<<10)), WithProgress(func(p Progress) {
+			progress = p
+		}))
+		require.Nil(err)
+		require.True(progress.RateLimitWait > 0)
+		require.Equal(b.RateLimitWait(), progress.RateLimitWait)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(0)
+		b.SetRateLimiter(newRateLimiter(t, 1<<10, 1<<10))
+		defer b.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := b.ReadFromContext(ctx, strings.NewReader(generateRandomString(10<<10)))
+		require.Equal(context.DeadlineExceeded, errors.Cause(err))
+		require.True(b.RateLimitWait() > 0)
+
+		// The context is used only during the call
+		require.Nil(b.ctx)
+	})
+}
@@ -0,0 +1,211 @@
+package buffer
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrReaderClosed is used when Reader is used after Reader.Close()
+	ErrReaderClosed = errors.New("reader is closed")
+)
+
+// Reader reads data of a Buffer. Every Reader has its own offset, so several Readers of the same Buffer
+// can be used concurrently. Readers share the memory and the temp file with the Buffer. The temp file
+// is removed when the Buffer and all its Readers are closed.
+//
+// Reader implements io.Reader, io.Seeker and io.Closer interfaces
+type Reader struct {
+	memory []byte
+	// file is used only when file.file isn't nil
+	file fileReader
+
+	// start and size are logical offsets of the first and the last bytes of the Buffer
+	start int64
+	size  int64
+	// pos is a position relative to start
+	pos int64
+
+	// wipeMemory is true when memory is a copy which must be zeroed on Close()
+	wipeMemory bool
+	// lockedMemory stores the copies of the memory and the encryption key if memory lock is enabled
+	lockedMemory *lockedMemory
+
+	closed bool
+}
+
+// NewReader finishes writing and returns a new Reader of the unread portion of the Buffer.
+// Reading from the Buffer or from other Readers doesn't affect the returned Reader.
+//
+// NewReader returns ErrRingModeUnsupported in ring mode
+func (b *Buffer) NewReader() (*Reader, error) {
+	if b.ringLimit != 0 {
+		return nil, ErrRingModeUnsupported
+	}
+
+	err := b.finishWriting()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{
+		memory: b.buff.Bytes(),
+		start:  b.offset,
+		size:   b.size,
+	}
+	switch {
+	case b.lockedMemory != nil:
+		// Locked memory is released on Buffer.Close(). So, Reader gets its own copy in locked memory.
+		// The memory also stores the cache of a decrypted package: [key][package][memory]
+		var packageSize int
+		if b.file != nil && b.fileReader.encrypt {
+			packageSize = encryptionPayloadSize
+		}
+		mem, err := lockMemory(encryptionKeySize + packageSize + len(r.memory))
+		if err != nil {
+			return nil, err
+		}
+		copy(mem.data[encryptionKeySize+packageSize:], r.memory)
+		r.memory = mem.data[encryptionKeySize+packageSize:]
+		r.lockedMemory = mem
+		r.wipeMemory = b.secureWipe
+	case b.secureWipe:
+		// Buffer must be able to zero its memory. So, Reader gets its own copy
+		r.memory = append([]byte(nil), r.memory...)
+		r.wipeMemory = true
+	default:
+		b.memoryShared = true
+	}
+
+	if b.file != nil {
+		b.file.retain()
+		r.file = fileReader{
+			file:          b.file,
+			encrypt:       b.fileReader.encrypt,
+			encryptionKey: r.copyKey(b.fileReader.encryptionKey),
+			cipherSuites:  b.fileReader.cipherSuites,
+			headerSize:    b.fileReader.headerSize,
+			waitIO:        newRateLimiterWait(b.rateLimiter),
+		}
+		if r.lockedMemory != nil && r.file.encrypt {
+			r.file.packageBuffer = r.lockedMemory.data[encryptionKeySize : encryptionKeySize+encryptionPayloadSize]
+		}
+	}
+
+	return r, nil
+}
+
+// Read reads data from memory or from a file
+func (r *Reader) Read(data []byte) (n int, err error) {
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+
+	rest := r.size - r.start - r.pos
+	if rest <= 0 {
+		if len(data) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	if int64(len(data)) > rest {
+		data = data[:rest]
+	}
+
+	n, err = readAt(r.memory, &r.file, data, r.start+r.pos)
+	r.pos += int64(n)
+
+	return n, err
+}
+
+// Seek implements io.Seeker interface. Offsets are relative to the first byte of the Reader
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.size - r.start + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.pos = pos
+
+	return pos, nil
+}
+
+// Len returns the number of bytes of the unread portion of the Reader. It returns the max int value
+// if the number doesn't fit in int. Use Reader.Remaining() instead
+func (r *Reader) Len() int {
+	return saturateInt(r.Remaining())
+}
+
+// Remaining returns the number of bytes of the unread portion of the Reader
+func (r *Reader) Remaining() int64 {
+	rest := r.size - r.start - r.pos
+	if rest < 0 {
+		return 0
+	}
+	return rest
+}
+
+// Size returns the original length of the Reader
+func (r *Reader) Size() int64 {
+	return r.size - r.start
+}
+
+// Close closes the Reader. The temp file is removed if the Buffer and all other Readers are closed
+func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	if r.wipeMemory {
+		wipe(r.memory)
+		r.file.wipe()
+	}
+	r.memory = nil
+
+	file := r.file.file
+	r.file = fileReader{}
+
+	var err error
+	if r.lockedMemory != nil {
+		err = r.lockedMemory.release()
+		r.lockedMemory = nil
+	}
+	if file != nil {
+		if releaseErr := file.release(); err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// copyKey returns a copy of the encryption key. The copy is stored in locked memory if it is used by the Reader
+func (r *Reader) copyKey(key []byte) []byte {
+	if key == nil {
+		return nil
+	}
+
+	var dst []byte
+	if r.lockedMemory != nil {
+		dst = r.lockedMemory.data[:encryptionKeySize:encryptionKeySize]
+	} else {
+		dst = make([]byte, encryptionKeySize)
+	}
+	copy(dst, key)
+	return dst
+}
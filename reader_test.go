@@ -0,0 +1,194 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_NewReader(t *testing.T) {
+	tests := []struct {
+		desc     string
+		maxSize  int
+		encrypt  bool
+		dataSize int
+		readSize int
+	}{
+		{desc: "memory", maxSize: 100, dataSize: 50},
+		{desc: "memory and file", maxSize: 100, dataSize: 1000},
+		{desc: "file", maxSize: 0, dataSize: 1000},
+		{desc: "after read", maxSize: 100, dataSize: 1000, readSize: 300},
+		{desc: "encrypted", maxSize: 100, encrypt: true, dataSize: 300 << 10},
+		{desc: "encrypted, after read", maxSize: 100, encrypt: true, dataSize: 300 << 10, readSize: 100 << 10},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			_, err = io.ReadFull(b, make([]byte, tt.readSize))
+			require.Nil(err)
+
+			expected := data[tt.readSize:]
+
+			// Read concurrently
+			const readersCount = 5
+
+			var (
+				wg      sync.WaitGroup
+				results = make([][]byte, readersCount)
+				errs    = make([]error, readersCount)
+			)
+			for i := 0; i < readersCount; i++ {
+				r, err := b.NewReader()
+				require.Nil(err)
+				require.Equal(len(expected), r.Len())
+
+				wg.Add(1)
+				go func(i int, r *Reader) {
+					defer wg.Done()
+					defer r.Close()
+
+					results[i], errs[i] = ioutil.ReadAll(r)
+				}(i, r)
+			}
+
+			// Read from the Buffer at the same time
+			res := readByChunks(require, b, 1000)
+			require.Equal(string(expected), string(res))
+
+			wg.Wait()
+
+			for i := 0; i < readersCount; i++ {
+				require.Nil(errs[i])
+				require.Equal(string(expected), string(results[i]))
+			}
+		})
+	}
+}
+
+func TestReader_Seek(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(200 << 10))
+
+			b := NewBufferWithMaxMemorySize(100)
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			_, err := b.Write(data)
+			require.Nil(err)
+
+			r, err := b.NewReader()
+			require.Nil(err)
+			defer r.Close()
+
+			require.Equal(int64(len(data)), r.Size())
+
+			checkRead := func(expected []byte) {
+				res := make([]byte, len(expected))
+				_, err := io.ReadFull(r, res)
+				require.Nil(err)
+				require.Equal(expected, res)
+			}
+
+			pos, err := r.Seek(150<<10, io.SeekStart)
+			require.Nil(err)
+			require.Equal(int64(150<<10), pos)
+			checkRead(data[150<<10 : 151<<10])
+
+			pos, err = r.Seek(-100<<10, io.SeekCurrent)
+			require.Nil(err)
+			require.Equal(int64(51<<10), pos)
+			checkRead(data[51<<10 : 52<<10])
+
+			pos, err = r.Seek(-10, io.SeekEnd)
+			require.Nil(err)
+			require.Equal(int64(len(data)-10), pos)
+			checkRead(data[len(data)-10:])
+
+			_, err = r.Read(make([]byte, 1))
+			require.Equal(io.EOF, err)
+
+			// Seek beyond the end
+			_, err = r.Seek(1, io.SeekEnd)
+			require.Nil(err)
+			require.Equal(0, r.Len())
+			_, err = r.Read(make([]byte, 1))
+			require.Equal(io.EOF, err)
+
+			_, err = r.Seek(-1, io.SeekStart)
+			require.NotNil(err)
+
+			pos, err = r.Seek(0, io.SeekStart)
+			require.Nil(err)
+			require.Equal(int64(0), pos)
+			checkRead(data)
+		})
+	}
+}
+
+func TestReader_Close(t *testing.T) {
+	require := require.New(t)
+
+	b := NewBufferWithMaxMemorySize(10)
+	_, err := b.Write([]byte(generateRandomString(100)))
+	require.Nil(err)
+
+	filename := b.filename
+
+	r1, err := b.NewReader()
+	require.Nil(err)
+	r2, err := b.NewReader()
+	require.Nil(err)
+
+	_, err = b.Write([]byte("1"))
+	require.Equal(ErrBufferFinished, err)
+
+	require.Nil(b.Close())
+	_, err = os.Stat(filename)
+	require.Nil(err, "file must not be removed: it is used by Readers")
+
+	require.Nil(r1.Close())
+	_, err = os.Stat(filename)
+	require.Nil(err, "file must not be removed: it is used by Readers")
+
+	// Reader can be used after Buffer.Close()
+	res, err := ioutil.ReadAll(r2)
+	require.Nil(err)
+	require.Len(res, 100)
+
+	require.Nil(r2.Close())
+	_, err = os.Stat(filename)
+	require.True(os.IsNotExist(err), "file must be removed")
+
+	_, err = r1.Read(make([]byte, 1))
+	require.Equal(ErrReaderClosed, err)
+}
@@ -0,0 +1,130 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTruncatedRecord is used when Buffer.ReadRecord() finds a record that is shorter than its length prefix
+	ErrTruncatedRecord = errors.New("truncated record")
+
+	// ErrInvalidRecordLength is used when Buffer.ReadRecord() finds an invalid length prefix
+	ErrInvalidRecordLength = errors.New("invalid record length")
+)
+
+// WriteRecord writes a record with a length prefix (uvarint). Records can be read with Buffer.ReadRecord()
+// or Buffer.Records().
+//
+// WriteRecord returns ErrBufferFinished after the call of Buffer.Read(), Buffer.ReadByte() or Buffer.Next()
+func (b *Buffer) WriteRecord(record []byte) error {
+	if b.ringLimit != 0 {
+		// The oldest records can be discarded partially
+		return ErrRingModeUnsupported
+	}
+
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(record)))
+
+	_, err := b.Write(header[:n])
+	if err != nil {
+		return err
+	}
+	_, err = b.Write(record)
+	return err
+}
+
+// ReadRecord reads a record written by Buffer.WriteRecord(). The record can be stored both in memory
+// and on a disk. It returns io.EOF if there are no more records and ErrTruncatedRecord if the last record
+// is incomplete. The read position isn't changed in case of an error, so the incomplete record can be
+// removed with Buffer.Truncate().
+//
+// ReadRecord finishes writing as Buffer.Read() does
+func (b *Buffer) ReadRecord() ([]byte, error) {
+	header, err := b.Peek(binary.MaxVarintLen64)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 0 {
+		// Call Read to finish reading: it removes the temp file
+		_, err = b.Read(nil)
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, err
+	}
+
+	length, headerSize := binary.Uvarint(header)
+	switch {
+	case headerSize == 0 && len(header) < binary.MaxVarintLen64:
+		return nil, ErrTruncatedRecord
+	case headerSize <= 0:
+		// A valid length always fits in binary.MaxVarintLen64 bytes
+		return nil, ErrInvalidRecordLength
+	case length > uint64(maxInt):
+		// The record can't be stored in a slice
+		return nil, ErrInvalidRecordLength
+	case length > uint64(b.Remaining()-int64(headerSize)):
+		return nil, ErrTruncatedRecord
+	}
+
+	record := make([]byte, length)
+	n, err := b.readAt(record, b.offset+int64(headerSize))
+	if err != nil {
+		return nil, err
+	}
+	if n != len(record) {
+		return nil, ErrTruncatedRecord
+	}
+
+	b.offset += int64(headerSize + n)
+	b.lastRead = true
+
+	return record, nil
+}
+
+// Records returns an iterator over records written by Buffer.WriteRecord()
+func (b *Buffer) Records() *RecordIterator {
+	return &RecordIterator{b: b}
+}
+
+// RecordIterator reads records from a Buffer one by one. Usage:
+//
+//	iter := b.Records()
+//	for iter.Next() {
+//		record := iter.Record()
+//		...
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type RecordIterator struct {
+	b      *Buffer
+	record []byte
+	err    error
+}
+
+// Next reads the next record. It returns false when there are no more records or an error occurred
+func (it *RecordIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.record, it.err = it.b.ReadRecord()
+	return it.err == nil
+}
+
+// Record returns the record read by the last call of Next
+func (it *RecordIterator) Record() []byte {
+	return it.record
+}
+
+// Err returns the first error that occurred during the iteration. It returns nil if all records were read
+func (it *RecordIterator) Err() error {
+	if it.err == io.EOF {
+		return nil
+	}
+	return it.err
+}
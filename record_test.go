@@ -0,0 +1,150 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_WriteRecord(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxInMemorySize int
+		encrypt         bool
+		sizes           []int
+	}{
+		{name: "empty", maxInMemorySize: 100, sizes: nil},
+		{name: "empty records", maxInMemorySize: 100, sizes: []int{0, 0, 0}},
+		{name: "memory", maxInMemorySize: 100, sizes: []int{5, 10, 20}},
+		{name: "memory and disk", maxInMemorySize: 100, sizes: []int{50, 300, 0, 45, 1 << 10}},
+		{name: "disk", maxInMemorySize: 0, sizes: []int{50, 300, 0, 45, 1 << 10}},
+		{name: "encrypted", maxInMemorySize: 100, encrypt: true, sizes: []int{50, 1 << 17, 3, 1 << 16}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(tt.maxInMemorySize)
+			if tt.encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+			defer b.Close()
+
+			var records []string
+			for _, size := range tt.sizes {
+				record := generateRandomString(size)
+				records = append(records, record)
+
+				require.Nil(b.WriteRecord([]byte(record)))
+			}
+
+			var res []string
+			iter := b.Records()
+			for iter.Next() {
+				res = append(res, string(iter.Record()))
+			}
+			require.Nil(iter.Err())
+			require.Equal(records, res)
+			require.Nil(b.file, "temp file must be removed")
+
+			_, err := b.ReadRecord()
+			require.Equal(io.EOF, err)
+		})
+	}
+}
+
+func TestBuffer_ReadRecord(t *testing.T) {
+	t.Run("truncated record", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		require.Nil(b.WriteRecord([]byte("hello")))
+		require.Nil(b.WriteRecord([]byte(generateRandomString(100))))
+		require.Nil(b.Truncate(b.Len() - 1))
+
+		record, err := b.ReadRecord()
+		require.Nil(err)
+		require.Equal("hello", string(record))
+
+		l := b.Len()
+		_, err = b.ReadRecord()
+		require.Equal(ErrTruncatedRecord, err)
+		require.Equal(l, b.Len(), "read position must not be changed")
+
+		// Drop the incomplete record
+		require.Nil(b.Truncate(0))
+		_, err = b.ReadRecord()
+		require.Equal(io.EOF, err)
+	})
+
+	t.Run("truncated length", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		_, err := b.Write([]byte{0x80, 0x80})
+		require.Nil(err)
+
+		iter := b.Records()
+		require.False(iter.Next())
+		require.Equal(ErrTruncatedRecord, iter.Err())
+	})
+
+	t.Run("invalid length", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		header := make([]byte, binary.MaxVarintLen64+1)
+		for i := range header {
+			header[i] = 0xff
+		}
+		_, err := b.Write(header)
+		require.Nil(err)
+
+		_, err = b.ReadRecord()
+		require.Equal(ErrInvalidRecordLength, err)
+	})
+
+	t.Run("too long record", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		defer b.Close()
+
+		header := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(header, uint64(maxInt)+1)
+		_, err := b.Write(header[:n])
+		require.Nil(err)
+		_, err = b.Write([]byte(generateRandomString(100)))
+		require.Nil(err)
+
+		_, err = b.ReadRecord()
+		require.Equal(ErrInvalidRecordLength, err)
+	})
+
+	t.Run("ring mode", func(t *testing.T) {
+		t.Parallel()
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(t, b.EnableRingMode(100))
+		require.Equal(t, ErrRingModeUnsupported, b.WriteRecord([]byte("hello")))
+	})
+}
@@ -0,0 +1,50 @@
+package buffer
+
+import (
+	"io"
+)
+
+// EnableTempFileReuse makes the Buffer keep the temp file after Buffer.Reset() and after reading
+// of all data. The file is truncated and used by the next spill, so a long-lived Buffer doesn't
+// create and remove a file every cycle. A new encryption key is generated for every cycle.
+// The file is removed by Buffer.Close().
+//
+// The file isn't reused if it is used by Readers or was committed with Buffer.CommitTo()
+func (b *Buffer) EnableTempFileReuse() {
+	b.reuseTempFile = true
+}
+
+// recycleTempFile truncates the current temp file and keeps it for the next spill. It returns false
+// if the file can't be reused
+func (b *Buffer) recycleTempFile() bool {
+	file := b.file
+	if file.shared() || file.renamed || b.spareFile != nil {
+		return false
+	}
+
+	if file.wipe {
+		if err := wipeFileContent(file.TempFile); err != nil {
+			return false
+		}
+	}
+	if err := file.Truncate(0); err != nil {
+		return false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+
+	b.spareFile = file
+	return true
+}
+
+// releaseSpareFile closes and removes the kept temp file
+func (b *Buffer) releaseSpareFile() error {
+	if b.spareFile == nil {
+		return nil
+	}
+
+	err := b.spareFile.release()
+	b.spareFile = nil
+	return err
+}
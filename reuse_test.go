@@ -0,0 +1,132 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_EnableTempFileReuse(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		encrypt := encrypt
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(10)
+			b.EnableTempFileReuse()
+			if encrypt {
+				require.Nil(b.EnableEncryption())
+			}
+
+			var (
+				filename string
+				key      []byte
+			)
+			for i := 0; i < 3; i++ {
+				data := generateRandomString(100 << 10)
+				_, err := b.WriteString(data)
+				require.Nil(err)
+
+				if i == 0 {
+					filename = b.filename
+				} else {
+					require.Equal(filename, b.filename, "file must be reused")
+				}
+				if encrypt {
+					require.NotEqual(key, b.encryptionKey, "key must be changed")
+					key = append([]byte(nil), b.encryptionKey...)
+				}
+
+				switch i {
+				case 0:
+					// Read all data
+					res, err := ioutil.ReadAll(b)
+					require.Nil(err)
+					require.Equal(data, string(res))
+				default:
+					res := make([]byte, 1<<10)
+					_, err := b.Read(res)
+					require.Nil(err)
+					require.Equal(data[:1<<10], string(res))
+				}
+				b.Reset()
+
+				info, err := os.Stat(filename)
+				require.Nil(err, "file must be kept")
+				require.Equal(int64(0), info.Size(), "file must be truncated")
+			}
+
+			require.Nil(b.Close())
+			_, err := os.Stat(filename)
+			require.True(os.IsNotExist(err), "file must be removed")
+		})
+	}
+
+	t.Run("shared file", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		b.EnableTempFileReuse()
+		defer b.Close()
+
+		data := generateRandomString(100)
+		_, err := b.WriteString(data)
+		require.Nil(err)
+		filename := b.filename
+
+		r, err := b.NewReader()
+		require.Nil(err)
+
+		// The file is used by the Reader, so it can't be reused
+		b.Reset()
+		_, err = b.WriteString(data)
+		require.Nil(err)
+		require.NotEqual(filename, b.filename)
+
+		res, err := ioutil.ReadAll(r)
+		require.Nil(err)
+		require.Equal(data, string(res))
+
+		require.Nil(r.Close())
+		_, err = os.Stat(filename)
+		require.True(os.IsNotExist(err), "file must be removed")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		b.EnableTempFileReuse()
+		require.Nil(b.EnableEncryption())
+		defer b.Close()
+
+		data := generateRandomString(100)
+		_, err := b.WriteString(data)
+		require.Nil(err)
+		b.Reset()
+		spare := b.spareFile.Name()
+
+		_, err = b.WriteString(data)
+		require.Nil(err)
+		encrypted := b.filename
+		require.Equal(spare, encrypted)
+
+		// Data is moved into a new file. The encrypted file is kept
+		f, err := b.File()
+		require.Nil(err)
+		require.NotEqual(encrypted, f.Name())
+		require.Equal(encrypted, b.spareFile.Name())
+
+		res, err := ioutil.ReadAll(f)
+		require.Nil(err)
+		require.Equal(data, string(res))
+	})
+}
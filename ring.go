@@ -0,0 +1,183 @@
+package buffer
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// In ring mode Buffer retains only the last ringLimit bytes. The newest bytes (up to maxInMemorySize)
+// are stored in memory, older ones – in a temp file. Both the memory and the file are used as circular
+// buffers: byte with logical offset x is stored at x % len(ringMemory) in memory or at x % ringFileSize()
+// in the file.
+//
+// Logical ranges:
+//
+//   - discarded and read bytes: [0, offset)
+//   - file: [offset, ringMemoryStart())
+//   - memory: [ringMemoryStart(), size)
+//
+
+// EnableRingMode makes Buffer retain only the last limit bytes: old data is discarded as new data
+// arrives. Buffer.Len() returns the size of the retained data, reading returns the retained data.
+//
+// Ring mode must be enabled before writing. Ring mode can't be used with encryption, memory lock,
+// file headers, Buffer.Truncate() and Buffer.WriteAt()
+func (b *Buffer) EnableRingMode(limit int64) error {
+	if limit <= 0 {
+		return errors.New("limit must be greater than zero")
+	}
+	if b.size != 0 {
+		return errors.New("ring mode must be enabled before writing")
+	}
+	if b.encrypt || b.lockedMemory != nil || b.fileHeader {
+		return ErrRingModeUnsupported
+	}
+
+	memorySize := b.maxInMemorySize
+	if int64(memorySize) > limit {
+		memorySize = int(limit)
+	}
+
+	b.ringLimit = limit
+	b.ringMemory = make([]byte, memorySize)
+	// bytes.Buffer isn't used in ring mode
+	b.buff = bytes.Buffer{}
+
+	return nil
+}
+
+// ringFileSize returns max size of the file in ring mode
+func (b *Buffer) ringFileSize() int64 {
+	return b.ringLimit - int64(len(b.ringMemory))
+}
+
+// ringMemoryStart returns logical offset of the first byte stored in memory in ring mode
+func (b *Buffer) ringMemoryStart() int64 {
+	start := b.size - int64(len(b.ringMemory))
+	if start < 0 {
+		start = 0
+	}
+	return start
+}
+
+func (b *Buffer) writeToRing(data []byte) (n int, err error) {
+	var (
+		memorySize = int64(len(b.ringMemory))
+		fileSize   = b.ringFileSize()
+		newSize    = b.size + int64(len(data))
+	)
+
+	// Move bytes that don't fit in memory anymore into the file. Skip bytes that will be discarded anyway
+	var (
+		from = b.ringMemoryStart()
+		to   = newSize - memorySize
+	)
+	if from < newSize-b.ringLimit {
+		from = newSize - b.ringLimit
+	}
+	if from < to && b.file == nil {
+		err = b.createTempFile()
+		if err != nil {
+			return 0, err
+		}
+	}
+	for from < to {
+		var chunk []byte
+		if from < b.size {
+			// From memory
+			start := from % memorySize
+			chunk = b.ringMemory[start:]
+			if rest := b.size - from; int64(len(chunk)) > rest {
+				chunk = chunk[:rest]
+			}
+		} else {
+			// From the new data
+			chunk = data[from-b.size:]
+		}
+		if rest := to - from; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		start := from % fileSize
+		if rest := fileSize - start; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		err = b.waitRateLimiter(len(chunk))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = b.file.WriteAt(chunk, start)
+		if err != nil {
+			return 0, errors.Wrapf(err, "can't write into a temp file '%s'", b.filename)
+		}
+		from += int64(len(chunk))
+
+		err = b.fileWritten(len(chunk))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Copy the newest bytes into memory
+	from = b.size
+	if from < newSize-memorySize {
+		from = newSize - memorySize
+	}
+	for from < newSize {
+		copied := copy(b.ringMemory[from%memorySize:], data[from-b.size:])
+		from += int64(copied)
+	}
+
+	b.size = newSize
+	if b.offset < newSize-b.ringLimit {
+		// Discard the oldest bytes
+		b.offset = newSize - b.ringLimit
+	}
+
+	return len(data), nil
+}
+
+// readFromRing fills data with bytes starting at passed logical offset
+func (b *Buffer) readFromRing(data []byte, off int64) (n int, err error) {
+	var (
+		memorySize  = int64(len(b.ringMemory))
+		fileSize    = b.ringFileSize()
+		memoryStart = b.ringMemoryStart()
+	)
+
+	for len(data) > 0 {
+		var copied int
+		if off >= memoryStart {
+			copied = copy(data, b.ringMemory[off%memorySize:])
+		} else {
+			start := off % fileSize
+
+			chunk := data
+			if rest := fileSize - start; int64(len(chunk)) > rest {
+				chunk = chunk[:rest]
+			}
+			if rest := memoryStart - off; int64(len(chunk)) > rest {
+				chunk = chunk[:rest]
+			}
+
+			err = b.waitRateLimiter(len(chunk))
+			if err != nil {
+				return n, err
+			}
+
+			copied, err = b.file.ReadAt(chunk, start)
+			if err != nil {
+				return n + copied, errors.Wrapf(err, "can't read from a temp file '%s'", b.filename)
+			}
+		}
+
+		data = data[copied:]
+		off += int64(copied)
+		n += copied
+	}
+
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package buffer
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_RingMode(t *testing.T) {
+	tests := []struct {
+		desc      string
+		maxSize   int
+		limit     int
+		dataSize  int
+		chunkSize int
+	}{
+		{desc: "less than limit", maxSize: 10, limit: 100, dataSize: 50, chunkSize: 7},
+		{desc: "memory only", maxSize: 100, limit: 50, dataSize: 500, chunkSize: 7},
+		{desc: "file only", maxSize: 0, limit: 50, dataSize: 500, chunkSize: 7},
+		{desc: "memory and file", maxSize: 20, limit: 50, dataSize: 500, chunkSize: 7},
+		{desc: "big chunks", maxSize: 20, limit: 50, dataSize: 500, chunkSize: 123},
+		{desc: "single chunk", maxSize: 20, limit: 50, dataSize: 500, chunkSize: 500},
+		{desc: "single byte", maxSize: 3, limit: 10, dataSize: 100, chunkSize: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			data := []byte(generateRandomString(tt.dataSize))
+
+			b := NewBufferWithMaxMemorySize(tt.maxSize)
+			err := b.EnableRingMode(int64(tt.limit))
+			require.Nil(err)
+			defer b.Reset()
+
+			for i := 0; i < len(data); i += tt.chunkSize {
+				bound := i + tt.chunkSize
+				if bound > len(data) {
+					bound = len(data)
+				}
+
+				n, err := b.Write(data[i:bound])
+				require.Nil(err)
+				require.Equal(bound-i, n)
+
+				expectedLen := bound
+				if expectedLen > tt.limit {
+					expectedLen = tt.limit
+				}
+				require.Equal(expectedLen, b.Len())
+			}
+
+			expected := data
+			if len(expected) > tt.limit {
+				expected = expected[len(expected)-tt.limit:]
+			}
+
+			peeked, err := b.Peek(len(expected))
+			require.Nil(err)
+			require.Equal(expected, peeked)
+
+			res := readByChunks(require, b, 3)
+			require.Equal(expected, res)
+		})
+	}
+}
+
+func TestBuffer_RingModeFuzz(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			var (
+				sliceSize      = rand.Intn(1<<10) + 1
+				limit          = rand.Intn(sliceSize) + 1
+				bufferSize     = rand.Intn(limit * 2) // can be zero
+				writeChunkSize = rand.Intn(sliceSize) + 1
+				readChunkSize  = rand.Intn(sliceSize) + 1
+			)
+
+			defer func() {
+				// Log only when test is failed
+				if t.Failed() {
+					t.Logf("sliceSize: %d; limit: %d; bufferSize: %d; writeChunkSize: %d; readChunkSize: %d\n",
+						sliceSize, limit, bufferSize, writeChunkSize, readChunkSize)
+				}
+			}()
+
+			slice := make([]byte, sliceSize)
+			for i := range slice {
+				slice[i] = byte(rand.Intn(128))
+			}
+
+			b := NewBufferWithMaxMemorySize(bufferSize)
+			require.Nil(b.EnableRingMode(int64(limit)))
+			defer b.Reset()
+
+			for i := 0; i < len(slice); i += writeChunkSize {
+				bound := i + writeChunkSize
+				if bound > len(slice) {
+					bound = len(slice)
+				}
+
+				_, err := b.Write(slice[i:bound])
+				require.Nil(err)
+			}
+
+			res := readByChunks(require, b, readChunkSize)
+			require.Equal(slice[len(slice)-limit:], res, "wrong content was read")
+		})
+	}
+}
+
+func TestBuffer_RingModeUnsupported(t *testing.T) {
+	require := require.New(t)
+
+	b := NewBufferWithMaxMemorySize(10)
+	defer b.Reset()
+
+	require.NotNil(b.EnableRingMode(0))
+	require.Nil(b.EnableRingMode(20))
+	require.Equal(ErrRingModeUnsupported, b.EnableEncryption())
+
+	_, err := b.Write([]byte(generateRandomString(30)))
+	require.Nil(err)
+
+	require.NotNil(b.EnableRingMode(20), "ring mode can't be enabled after writing")
+
+	_, err = b.WriteAt([]byte("1"), 15)
+	require.Equal(ErrRingModeUnsupported, err)
+	require.Equal(ErrRingModeUnsupported, b.Truncate(1))
+
+	// Encryption is enabled
+	b = NewBufferWithMaxMemorySize(10)
+	defer b.Reset()
+
+	require.Nil(b.EnableEncryption())
+	require.Equal(ErrRingModeUnsupported, b.EnableRingMode(20))
+}
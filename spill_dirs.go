@@ -0,0 +1,146 @@
+package buffer
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInsufficientSpace is used when there's no spill directory with enough free space for a temp file
+	ErrInsufficientSpace = errors.New("insufficient space in spill directories")
+)
+
+// minSpillSize is a min number of bytes that must be available in a spill directory when the size
+// of the data is unknown
+const minSpillSize = 1 << 20
+
+// DirSelection defines how a spill directory is chosen
+type DirSelection int
+
+const (
+	// FirstFit chooses the first directory with enough free space
+	FirstFit DirSelection = iota
+	// RoundRobin spreads temp files across directories with enough free space
+	RoundRobin
+)
+
+// SpillDirsOptions are used to configure SpillDirs
+type SpillDirsOptions struct {
+	// Selection defines how a directory is chosen. FirstFit is used by default
+	Selection DirSelection
+	// MinFreeSpace is a number of bytes that must stay free in a directory after the creation
+	// of a temp file
+	MinFreeSpace int64
+}
+
+// SpillDirs is an ordered list of directories for temp files. Before the creation of a temp file
+// free space of directories is checked (with statfs on Unix). The number of required bytes is known
+// only if Buffer.SizeHint() was called. Otherwise, a directory must have at least as much free space
+// as the max in-memory size of the Buffer (but not less than 1MiB). If a directory runs out of space
+// during the creation of a temp file (ENOSPC), the next one is used.
+//
+// SpillDirs is thread-safe, so it can be shared by several Buffers
+type SpillDirs struct {
+	dirs []string
+	opts SpillDirsOptions
+
+	// next is an index of the next directory for RoundRobin
+	next uint32
+
+	// freeSpace returns free space of a directory. ok is false if free space is unknown
+	freeSpace func(dir string) (size int64, ok bool, err error)
+}
+
+// NewSpillDirs checks passed directories and creates a new SpillDirs
+func NewSpillDirs(dirs []string, opts SpillDirsOptions) (*SpillDirs, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("no spill directories")
+	}
+
+	paths := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		path, err := checkDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return &SpillDirs{
+		dirs:      paths,
+		opts:      opts,
+		freeSpace: freeSpace,
+	}, nil
+}
+
+// candidates returns directories with enough free space for required bytes in the order of usage.
+// It returns ErrInsufficientSpace if there are no such directories
+func (d *SpillDirs) candidates(required int64) ([]string, error) {
+	start := 0
+	if d.opts.Selection == RoundRobin {
+		start = int((atomic.AddUint32(&d.next, 1) - 1) % uint32(len(d.dirs)))
+	}
+
+	need := required + d.opts.MinFreeSpace
+
+	res := make([]string, 0, len(d.dirs))
+	for i := range d.dirs {
+		dir := d.dirs[(start+i)%len(d.dirs)]
+
+		free, ok, err := d.freeSpace(dir)
+		if err != nil {
+			// Skip unavailable directories
+			continue
+		}
+		if ok && free < need {
+			continue
+		}
+		res = append(res, dir)
+	}
+
+	if len(res) == 0 {
+		return nil, ErrInsufficientSpace
+	}
+	return res, nil
+}
+
+// SetSpillDirs sets directories for temp files. They override the directory set by Buffer.ChangeTempDir().
+// A directory is chosen only once, when the temp file is created: if it runs out of space later
+// (for example, ENOSPC during Buffer.Write()), the error is returned and the data isn't moved into
+// another directory. Use Buffer.SizeHint() to reserve the space in advance. Pass nil to use a single
+// directory again
+func (b *Buffer) SetSpillDirs(dirs *SpillDirs) {
+	b.spillDirs = dirs
+}
+
+// spillDirsRequirement returns a number of bytes that must be available in a spill directory.
+// required is a number of bytes that will be preallocated
+func (b *Buffer) spillDirsRequirement(required int64) int64 {
+	if b.sizeHint != 0 || b.ringLimit != 0 {
+		return required
+	}
+
+	// Size is unknown. The data doesn't fit in memory, so at least the same amount is expected
+	size := b.maxMemorySize()
+	if size < minSpillSize {
+		size = minSpillSize
+	}
+	return required + b.physicalSize(int64(size))
+}
+
+// isNoSpaceError returns true if err is caused by ENOSPC
+func isNoSpaceError(err error) bool {
+	switch err := errors.Cause(err).(type) {
+	case *os.PathError:
+		return err.Err == syscall.ENOSPC
+	case *os.SyscallError:
+		return err.Err == syscall.ENOSPC
+	case syscall.Errno:
+		return err == syscall.ENOSPC
+	default:
+		return false
+	}
+}
@@ -0,0 +1,212 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createSpillDirs(t *testing.T, count int) (root string, dirs []string) {
+	root, err := ioutil.TempDir("", "go-disk-buffer-test-")
+	require.Nil(t, err)
+
+	for i := 0; i < count; i++ {
+		dir := filepath.Join(root, string(rune('a'+i)))
+		require.Nil(t, os.Mkdir(dir, 0700))
+		dirs = append(dirs, dir)
+	}
+	return root, dirs
+}
+
+func TestNewSpillDirs(t *testing.T) {
+	require := require.New(t)
+
+	root, dirs := createSpillDirs(t, 2)
+	defer os.RemoveAll(root)
+
+	_, err := NewSpillDirs(nil, SpillDirsOptions{})
+	require.NotNil(err)
+
+	_, err = NewSpillDirs(append(dirs, filepath.Join(root, "missing")), SpillDirsOptions{})
+	require.NotNil(err)
+
+	d, err := NewSpillDirs(dirs, SpillDirsOptions{})
+	require.Nil(err)
+	require.Equal(dirs, d.dirs)
+
+	free, ok, err := d.freeSpace(dirs[0])
+	require.Nil(err)
+	if ok {
+		require.True(free > 0)
+	}
+}
+
+func TestBuffer_SetSpillDirs(t *testing.T) {
+	t.Run("first fit", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		root, dirs := createSpillDirs(t, 3)
+		defer os.RemoveAll(root)
+
+		d, err := NewSpillDirs(dirs, SpillDirsOptions{Selection: FirstFit, MinFreeSpace: 100})
+		require.Nil(err)
+		d.freeSpace = func(dir string) (int64, bool, error) {
+			switch dir {
+			case dirs[0]:
+				return 2 << 20, true, nil
+			case dirs[1]:
+				return 10 << 20, true, nil
+			default:
+				return 0, false, nil
+			}
+		}
+
+		b := NewBufferWithMaxMemorySize(10)
+		b.SetSpillDirs(d)
+		defer b.Close()
+
+		// The first directory has enough space
+		_, err = b.Write(make([]byte, 100))
+		require.Nil(err)
+		require.Equal(dirs[0], filepath.Dir(b.filename))
+
+		// The first directory doesn't have enough space
+		b.Reset()
+		require.Nil(b.SizeHint(4 << 20))
+		require.Equal(dirs[1], filepath.Dir(b.filename))
+
+		// Free space of the last directory is unknown
+		b.Reset()
+		require.Nil(b.SizeHint(100 << 20))
+		require.Equal(dirs[2], filepath.Dir(b.filename))
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		root, dirs := createSpillDirs(t, 3)
+		defer os.RemoveAll(root)
+
+		d, err := NewSpillDirs(dirs, SpillDirsOptions{Selection: RoundRobin})
+		require.Nil(err)
+		d.freeSpace = func(dir string) (int64, bool, error) {
+			if dir == dirs[1] {
+				return 0, true, nil
+			}
+			return 10 << 20, true, nil
+		}
+
+		var used []string
+		for i := 0; i < 4; i++ {
+			b := NewBufferWithMaxMemorySize(0)
+			b.SetSpillDirs(d)
+
+			_, err := b.Write([]byte("hello"))
+			require.Nil(err)
+			used = append(used, filepath.Dir(b.filename))
+			require.Nil(b.Close())
+		}
+		// The second directory is full, so the third one is used instead
+		require.Equal([]string{dirs[0], dirs[2], dirs[2], dirs[0]}, used)
+	})
+
+	t.Run("insufficient space", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		root, dirs := createSpillDirs(t, 2)
+		defer os.RemoveAll(root)
+
+		d, err := NewSpillDirs(dirs, SpillDirsOptions{})
+		require.Nil(err)
+		d.freeSpace = func(dir string) (int64, bool, error) {
+			return 512 << 10, true, nil
+		}
+
+		b := NewBufferWithMaxMemorySize(10)
+		b.SetSpillDirs(d)
+		defer b.Close()
+
+		require.Equal(ErrInsufficientSpace, b.SizeHint(1<<20))
+		require.Nil(b.file)
+
+		// Size is unknown, so at least 1MiB is required
+		b.Reset()
+		_, err = b.Write(make([]byte, 100))
+		require.Equal(ErrInsufficientSpace, err)
+		require.Nil(b.file)
+
+		b.Reset()
+		require.Nil(b.SizeHint(100))
+		require.NotNil(b.file)
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		root, dirs := createSpillDirs(t, 2)
+		defer os.RemoveAll(root)
+
+		d, err := NewSpillDirs(dirs, SpillDirsOptions{})
+		require.Nil(err)
+		d.freeSpace = func(dir string) (int64, bool, error) {
+			if dir == dirs[0] {
+				return 3 << 20, true, nil
+			}
+			return 10 << 20, true, nil
+		}
+
+		// Data that doesn't fit in 4MiB of memory won't fit in 3MiB on a disk either
+		b := NewBufferWithMaxMemorySize(4 << 20)
+		b.SetSpillDirs(d)
+		defer b.Close()
+
+		_, err = b.Write(make([]byte, 5<<20))
+		require.Nil(err)
+		require.Equal(dirs[1], filepath.Dir(b.filename))
+	})
+
+	t.Run("ENOSPC", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		root, dirs := createSpillDirs(t, 2)
+		defer os.RemoveAll(root)
+
+		d, err := NewSpillDirs(dirs, SpillDirsOptions{})
+		require.Nil(err)
+
+		full := map[string]bool{dirs[0]: true}
+		b := NewBufferWithMaxMemorySize(10)
+		b.SetSpillDirs(d)
+		b.SetTempFileCreator(func(dir string) (TempFile, error) {
+			if full[dir] {
+				return nil, &os.PathError{Op: "open", Path: dir, Err: syscall.ENOSPC}
+			}
+			return ioutil.TempFile(dir, "go-disk-buffer-*.tmp")
+		})
+		defer b.Close()
+
+		_, err = b.Write(make([]byte, 100))
+		require.Nil(err)
+		require.Equal(dirs[1], filepath.Dir(b.filename))
+
+		// All directories are full
+		b.Reset()
+		full[dirs[1]] = true
+		_, err = b.Write(make([]byte, 100))
+		require.Equal(ErrInsufficientSpace, err)
+	})
+}
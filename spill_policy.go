@@ -0,0 +1,200 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultCgroupDir is a directory where cgroup filesystem is usually mounted
+	DefaultCgroupDir = "/sys/fs/cgroup"
+	// DefaultMeminfoPath is a path of the Linux meminfo file
+	DefaultMeminfoPath = "/proc/meminfo"
+)
+
+// SpillPolicy decides how many bytes a Buffer can store in memory before spilling data to a disk.
+// It is consulted by Buffer.Write() until the Buffer starts to use a temp file. SpillPolicy can be
+// shared by several Buffers, so it must be thread-safe
+type SpillPolicy interface {
+	// MaxMemorySize returns a max number of bytes a Buffer can store in memory
+	MaxMemorySize() int
+}
+
+// FixedSpillPolicy always returns the same size. Buffer.SetSpillPolicy(FixedSpillPolicy(n)) has the same
+// effect as the max memory size passed to NewBufferWithMaxMemorySize(). Buffer doesn't use any SpillPolicy
+// by default
+type FixedSpillPolicy int
+
+// MaxMemorySize returns the fixed size
+func (p FixedSpillPolicy) MaxMemorySize() int {
+	return int(p)
+}
+
+// AdaptiveSpillOptions is used to configure adaptive spill policies. An adaptive policy allows
+// a Buffer to use Fraction of available memory, but no less than MinMemorySize and no more than
+// MaxMemorySize
+type AdaptiveSpillOptions struct {
+	// MinMemorySize is used when there's no available memory
+	MinMemorySize int
+	// MaxMemorySize is used when there's plenty of available memory. DefaultMaxMemorySize is used by default
+	MaxMemorySize int
+	// Fraction is a fraction of available memory a Buffer can use. 0.1 is used by default
+	Fraction float64
+	// RefreshInterval is an interval between reads of memory statistics. 1s is used by default
+	RefreshInterval time.Duration
+}
+
+// adaptiveSpillPolicy is a base for all adaptive policies
+type adaptiveSpillPolicy struct {
+	opts AdaptiveSpillOptions
+	// availableMemory returns a number of bytes that are still available.
+	// math.MaxInt64 means there's no limit
+	availableMemory func() (int64, error)
+
+	mu        sync.Mutex
+	size      int
+	updatedAt time.Time
+}
+
+func newAdaptiveSpillPolicy(opts AdaptiveSpillOptions, availableMemory func() (int64, error)) *adaptiveSpillPolicy {
+	if opts.MaxMemorySize == 0 {
+		opts.MaxMemorySize = DefaultMaxMemorySize
+	}
+	if opts.Fraction == 0 {
+		opts.Fraction = 0.1
+	}
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = time.Second
+	}
+
+	return &adaptiveSpillPolicy{
+		opts:            opts,
+		availableMemory: availableMemory,
+	}
+}
+
+// MaxMemorySize returns a max number of bytes a Buffer can store in memory. MinMemorySize is returned
+// if memory statistics can't be read
+func (p *adaptiveSpillPolicy) MaxMemorySize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.updatedAt.IsZero() && time.Since(p.updatedAt) < p.opts.RefreshInterval {
+		return p.size
+	}
+
+	p.size = p.opts.MinMemorySize
+	if available, err := p.availableMemory(); err == nil {
+		size := float64(available) * p.opts.Fraction
+		switch {
+		case size > float64(p.opts.MaxMemorySize):
+			p.size = p.opts.MaxMemorySize
+		case size > float64(p.opts.MinMemorySize):
+			p.size = int(size)
+		}
+	}
+	p.updatedAt = time.Now()
+
+	return p.size
+}
+
+// NewCgroupSpillPolicy returns a policy that uses the memory limit and the memory usage of a cgroup.
+// dir is a directory of the cgroup, usually DefaultCgroupDir. Both cgroup v2 (memory.max and
+// memory.current files) and cgroup v1 (memory/memory.limit_in_bytes and memory/memory.usage_in_bytes
+// files) are supported
+func NewCgroupSpillPolicy(dir string, opts AdaptiveSpillOptions) SpillPolicy {
+	return newAdaptiveSpillPolicy(opts, func() (int64, error) {
+		return cgroupAvailableMemory(dir)
+	})
+}
+
+func cgroupAvailableMemory(dir string) (int64, error) {
+	limitFile := filepath.Join(dir, "memory.max")
+	usageFile := filepath.Join(dir, "memory.current")
+	if _, err := os.Stat(limitFile); os.IsNotExist(err) {
+		// cgroup v1
+		limitFile = filepath.Join(dir, "memory", "memory.limit_in_bytes")
+		usageFile = filepath.Join(dir, "memory", "memory.usage_in_bytes")
+	}
+
+	limit, err := ioutil.ReadFile(limitFile)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read the memory limit")
+	}
+	limit = bytes.TrimSpace(limit)
+	if string(limit) == "max" {
+		return math.MaxInt64, nil
+	}
+
+	usage, err := ioutil.ReadFile(usageFile)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read the memory usage")
+	}
+
+	return availableMemory(string(limit), string(bytes.TrimSpace(usage)))
+}
+
+func availableMemory(limit, usage string) (int64, error) {
+	limitValue, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid memory limit '%s'", limit)
+	}
+	usageValue, err := strconv.ParseInt(usage, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid memory usage '%s'", usage)
+	}
+
+	if usageValue > limitValue {
+		return 0, nil
+	}
+	return limitValue - usageValue, nil
+}
+
+// NewMeminfoSpillPolicy returns a policy that uses MemAvailable field of the Linux meminfo file.
+// path is a path of the file, usually DefaultMeminfoPath
+func NewMeminfoSpillPolicy(path string, opts AdaptiveSpillOptions) SpillPolicy {
+	return newAdaptiveSpillPolicy(opts, func() (int64, error) {
+		return meminfoAvailableMemory(path)
+	})
+}
+
+func meminfoAvailableMemory(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read meminfo")
+	}
+
+	// Line format: "MemAvailable:    1234567 kB"
+	prefix := []byte("MemAvailable:")
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Bytes()
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+
+		fields := bytes.Fields(line[len(prefix):])
+		if len(fields) == 0 {
+			break
+		}
+		value, err := strconv.ParseInt(string(fields[0]), 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid MemAvailable value '%s'", fields[0])
+		}
+		if len(fields) > 1 && string(fields[1]) == "kB" {
+			value *= 1 << 10
+		}
+		return value, nil
+	}
+
+	return 0, errors.New("meminfo doesn't contain MemAvailable field")
+}
@@ -0,0 +1,38 @@
+//go:build go1.19
+// +build go1.19
+
+package buffer
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+// heapObjectsMetric is a runtime metric with the size of live and unswept heap objects
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// NewHeapSpillPolicy returns a policy that uses the size of the Go heap reported by runtime/metrics.
+// Available memory is heapLimit minus the heap size. If heapLimit is zero, the soft memory limit
+// of the runtime (GOMEMLIMIT) is used
+func NewHeapSpillPolicy(heapLimit int64, opts AdaptiveSpillOptions) SpillPolicy {
+	return newAdaptiveSpillPolicy(opts, func() (int64, error) {
+		limit := heapLimit
+		if limit == 0 {
+			// A negative value doesn't change the limit
+			limit = debug.SetMemoryLimit(-1)
+		}
+		if limit == math.MaxInt64 {
+			return math.MaxInt64, nil
+		}
+
+		sample := []metrics.Sample{{Name: heapObjectsMetric}}
+		metrics.Read(sample)
+
+		heapSize := int64(sample[0].Value.Uint64())
+		if heapSize > limit {
+			return 0, nil
+		}
+		return limit - heapSize, nil
+	})
+}
@@ -0,0 +1,24 @@
+//go:build go1.19
+// +build go1.19
+
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeapSpillPolicy(t *testing.T) {
+	require := require.New(t)
+
+	opts := AdaptiveSpillOptions{MinMemorySize: 10, MaxMemorySize: 1 << 20}
+
+	// The limit is reached
+	policy := NewHeapSpillPolicy(1, opts)
+	require.Equal(10, policy.MaxMemorySize())
+
+	// There's plenty of memory
+	policy = NewHeapSpillPolicy(1<<40, opts)
+	require.Equal(1<<20, policy.MaxMemorySize())
+}
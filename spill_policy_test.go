@@ -0,0 +1,229 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testSpillPolicy returns sizes one by one
+type testSpillPolicy struct {
+	sizes []int
+}
+
+func (p *testSpillPolicy) MaxMemorySize() int {
+	size := p.sizes[0]
+	if len(p.sizes) > 1 {
+		p.sizes = p.sizes[1:]
+	}
+	return size
+}
+
+func TestBuffer_SetSpillPolicy(t *testing.T) {
+	tests := []struct {
+		desc   string
+		policy SpillPolicy
+		writes []int
+		//
+		memorySize int
+	}{
+		{desc: "fixed", policy: FixedSpillPolicy(50), writes: []int{30, 30}, memorySize: 50},
+		{desc: "increased", policy: &testSpillPolicy{sizes: []int{10, 100}}, writes: []int{10, 30}, memorySize: 40},
+		{desc: "decreased", policy: &testSpillPolicy{sizes: []int{100, 10}}, writes: []int{30, 30}, memorySize: 30},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			b := NewBufferWithMaxMemorySize(1)
+			b.SetSpillPolicy(tt.policy)
+			defer b.Close()
+
+			var data []byte
+			for _, size := range tt.writes {
+				chunk := []byte(generateRandomString(size))
+				data = append(data, chunk...)
+
+				_, err := b.Write(chunk)
+				require.Nil(err)
+			}
+
+			require.Equal(tt.memorySize, b.buff.Len())
+
+			res := readByChunks(require, b, 7)
+			require.Equal(data, res)
+		})
+	}
+}
+
+func TestCgroupSpillPolicy(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files map[string]string
+		opts  AdaptiveSpillOptions
+		//
+		size int
+	}{
+		{
+			desc:  "cgroup v2",
+			files: map[string]string{"memory.max": "1000000\n", "memory.current": "600000\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 100000, Fraction: 0.1},
+			size:  40000,
+		},
+		{
+			desc:  "cgroup v2, max",
+			files: map[string]string{"memory.max": "1000000\n", "memory.current": "600000\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000, Fraction: 0.1},
+			size:  1000,
+		},
+		{
+			desc:  "cgroup v2, no limit",
+			files: map[string]string{"memory.max": "max\n", "memory.current": "600000\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000},
+			size:  1000,
+		},
+		{
+			desc:  "cgroup v2, limit is reached",
+			files: map[string]string{"memory.max": "1000000\n", "memory.current": "1000001\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000},
+			size:  100,
+		},
+		{
+			desc:  "cgroup v1",
+			files: map[string]string{"memory/memory.limit_in_bytes": "1000000\n", "memory/memory.usage_in_bytes": "500000\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000000, Fraction: 0.5},
+			size:  250000,
+		},
+		{
+			desc:  "no files",
+			files: map[string]string{},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000},
+			size:  100,
+		},
+		{
+			desc:  "invalid file",
+			files: map[string]string{"memory.max": "1000000\n", "memory.current": "abc\n"},
+			opts:  AdaptiveSpillOptions{MinMemorySize: 100, MaxMemorySize: 1000},
+			size:  100,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			dir := writeTestFiles(require, tt.files)
+			defer os.RemoveAll(dir)
+
+			policy := NewCgroupSpillPolicy(dir, tt.opts)
+			require.Equal(tt.size, policy.MaxMemorySize())
+		})
+	}
+
+	t.Run("refresh", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		dir := writeTestFiles(require, map[string]string{"memory.max": "1000", "memory.current": "0"})
+		defer os.RemoveAll(dir)
+
+		policy := NewCgroupSpillPolicy(dir, AdaptiveSpillOptions{
+			MaxMemorySize:   1000,
+			Fraction:        1,
+			RefreshInterval: 50 * time.Millisecond,
+		})
+		require.Equal(1000, policy.MaxMemorySize())
+
+		err := ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("900"), 0600)
+		require.Nil(err)
+
+		// Cached value
+		require.Equal(1000, policy.MaxMemorySize())
+
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(100, policy.MaxMemorySize())
+	})
+}
+
+func TestMeminfoSpillPolicy(t *testing.T) {
+	const meminfo = `MemTotal:       16314516 kB
+MemFree:          589416 kB
+MemAvailable:    8000000 kB
+Buffers:          523472 kB
+Cached:          6933476 kB
+`
+
+	tests := []struct {
+		desc    string
+		meminfo string
+		opts    AdaptiveSpillOptions
+		//
+		size int
+	}{
+		{
+			desc:    "ok",
+			meminfo: meminfo,
+			opts:    AdaptiveSpillOptions{MaxMemorySize: 1 << 30, Fraction: 0.001},
+			size:    8192000,
+		},
+		{
+			desc:    "max",
+			meminfo: meminfo,
+			opts:    AdaptiveSpillOptions{MaxMemorySize: 1 << 20},
+			size:    1 << 20,
+		},
+		{
+			desc:    "no MemAvailable",
+			meminfo: "MemTotal:       16314516 kB\n",
+			opts:    AdaptiveSpillOptions{MinMemorySize: 10, MaxMemorySize: 1 << 20},
+			size:    10,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			require := require.New(t)
+
+			dir := writeTestFiles(require, map[string]string{"meminfo": tt.meminfo})
+			defer os.RemoveAll(dir)
+
+			policy := NewMeminfoSpillPolicy(filepath.Join(dir, "meminfo"), tt.opts)
+			require.Equal(tt.size, policy.MaxMemorySize())
+		})
+	}
+}
+
+// writeTestFiles creates a temp dir with passed files
+func writeTestFiles(require *require.Assertions, files map[string]string) (dir string) {
+	dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+	require.Nil(err)
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		require.Nil(err)
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		require.Nil(err)
+	}
+
+	return dir
+}
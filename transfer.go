@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// transferChunkSize is a size of a chunk used by Buffer.ReadFromContext() and Buffer.WriteToContext()
+const transferChunkSize = 512
+
+// Progress describes the state of Buffer.ReadFromContext() and Buffer.WriteToContext()
+type Progress struct {
+	// Transferred is a number of transferred bytes
+	Transferred int64
+	// Memory is a number of transferred bytes that were written into memory or read from memory
+	Memory int64
+	// Disk is a number of transferred bytes that were written on a disk or read from a disk
+	Disk int64
+	// RateLimitWait is the time spent waiting for the rate limiter (see Buffer.SetRateLimiter())
+	RateLimitWait time.Duration
+}
+
+type transferOptions struct {
+	progress       func(Progress)
+	cleanUpOnAbort bool
+}
+
+// TransferOption is an option for Buffer.ReadFromContext() and Buffer.WriteToContext()
+type TransferOption func(*transferOptions)
+
+// WithProgress sets a callback which is called after every transferred chunk
+func WithProgress(fn func(Progress)) TransferOption {
+	return func(opts *transferOptions) {
+		opts.progress = fn
+	}
+}
+
+// WithCleanUpOnAbort makes Buffer.ReadFromContext() reset the Buffer and remove the temp file
+// when the context is canceled or an error occurred
+func WithCleanUpOnAbort() TransferOption {
+	return func(opts *transferOptions) {
+		opts.cleanUpOnAbort = true
+	}
+}
+
+func newTransferOptions(opts []TransferOption) transferOptions {
+	var res transferOptions
+	for _, opt := range opts {
+		opt(&res)
+	}
+	return res
+}
+
+// ReadFromContext reads data from r until EOF and writes it into the Buffer. It stops between chunks
+// when ctx is canceled and returns ctx.Err()
+func (b *Buffer) ReadFromContext(ctx context.Context, r io.Reader, opts ...TransferOption) (n int64, err error) {
+	options := newTransferOptions(opts)
+
+	defer func() {
+		if err != nil && options.cleanUpOnAbort {
+			b.Reset()
+		}
+	}()
+
+	// Use ctx for rate limiting
+	b.ctx = ctx
+	defer func() {
+		b.ctx = nil
+	}()
+
+	var (
+		progress      Progress
+		rateLimitWait = b.rateLimitWait
+	)
+
+	var data = make([]byte, transferChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		rN, rErr := r.Read(data)
+		if rErr != nil && rErr != io.EOF {
+			return n, errors.Wrap(rErr, "can't read data from passed io.Reader")
+		}
+
+		data = data[:rN]
+		from := b.size
+		wN, wErr := b.Write(data)
+		if wErr != nil {
+			return n + int64(wN), errors.Wrap(wErr, "can't write data")
+		}
+		n += int64(rN)
+
+		if options.progress != nil && rN > 0 {
+			memory, disk := b.splitByStorage(from, b.size)
+			progress.Transferred = n
+			progress.Memory += memory
+			progress.Disk += disk
+			progress.RateLimitWait = b.rateLimitWait - rateLimitWait
+			options.progress(progress)
+		}
+
+		if rErr == io.EOF {
+			return n, nil
+		}
+
+		data = data[:cap(data)]
+	}
+}
+
+// WriteToContext writes data to w until the buffer is drained or an error occurs. It stops between
+// chunks when ctx is canceled and returns ctx.Err()
+func (b *Buffer) WriteToContext(ctx context.Context, w io.Writer, opts ...TransferOption) (int64, error) {
+	options := newTransferOptions(opts)
+
+	// Use ctx for rate limiting
+	b.ctx = ctx
+	defer func() {
+		b.ctx = nil
+	}()
+
+	var (
+		n             int64
+		progress      Progress
+		rateLimitWait = b.rateLimitWait
+	)
+
+	data := make([]byte, transferChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		from := b.offset
+		rN, rErr := b.Read(data)
+		if rErr != nil && rErr != io.EOF {
+			return n, errors.Wrap(rErr, "can't read data from Buffer")
+		}
+
+		data = data[:rN]
+		wN, wErr := w.Write(data)
+		if wErr != nil {
+			return n + int64(wN), errors.Wrap(wErr, "can't write data into io.Writer")
+		}
+		n += int64(rN)
+
+		if options.progress != nil && rN > 0 {
+			memory, disk := b.splitByStorage(from, from+int64(rN))
+			progress.Transferred = n
+			progress.Memory += memory
+			progress.Disk += disk
+			progress.RateLimitWait = b.rateLimitWait - rateLimitWait
+			options.progress(progress)
+		}
+
+		if rErr == io.EOF {
+			return n, nil
+		}
+
+		data = data[:cap(data)]
+	}
+}
+
+// splitByStorage returns how many bytes with logical offsets [from, to) are stored in memory and on a disk
+func (b *Buffer) splitByStorage(from, to int64) (memory, disk int64) {
+	// Bytes [memoryFrom, memoryTo) are stored in memory
+	memoryFrom, memoryTo := int64(0), int64(b.buff.Len())
+	if b.ringLimit != 0 {
+		memoryFrom, memoryTo = b.ringMemoryStart(), b.size
+	}
+
+	start, end := from, to
+	if start < memoryFrom {
+		start = memoryFrom
+	}
+	if end > memoryTo {
+		end = memoryTo
+	}
+	if start < end {
+		memory = end - start
+	}
+
+	return memory, to - from - memory
+}
@@ -0,0 +1,159 @@
+package buffer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cancelingReader cancels the context after the passed number of reads
+type cancelingReader struct {
+	r      io.Reader
+	reads  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.reads--
+	if r.reads == 0 {
+		r.cancel()
+	}
+	return r.r.Read(p)
+}
+
+func TestBuffer_ReadFromContext(t *testing.T) {
+	t.Run("progress", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		data := []byte(generateRandomString(2000))
+
+		b := NewBufferWithMaxMemorySize(700)
+		defer b.Close()
+
+		var last Progress
+		n, err := b.ReadFromContext(context.Background(), bytes.NewReader(data), WithProgress(func(p Progress) {
+			require.True(p.Transferred > last.Transferred)
+			last = p
+		}))
+		require.Nil(err)
+		require.Equal(int64(len(data)), n)
+		require.Equal(Progress{Transferred: 2000, Memory: 700, Disk: 1300}, last)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		data := []byte(generateRandomString(5000))
+
+		b := NewBufferWithMaxMemorySize(700)
+		defer b.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := &cancelingReader{r: bytes.NewReader(data), reads: 3, cancel: cancel}
+		n, err := b.ReadFromContext(ctx, r)
+		require.Equal(context.Canceled, err)
+		require.Equal(int64(3*transferChunkSize), n)
+		require.Equal(3*transferChunkSize, b.Len())
+
+		filename := b.filename
+		require.NotEmpty(filename)
+		_, err = os.Stat(filename)
+		require.Nil(err, "file must not be removed without WithCleanUpOnAbort()")
+	})
+
+	t.Run("cancel with clean up", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		data := []byte(generateRandomString(5000))
+
+		b := NewBufferWithMaxMemorySize(700)
+		defer b.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := &cancelingReader{r: bytes.NewReader(data), reads: 3, cancel: cancel}
+
+		var filename string
+		_, err := b.ReadFromContext(ctx, r, WithCleanUpOnAbort(), WithProgress(func(Progress) {
+			if b.filename != "" {
+				filename = b.filename
+			}
+		}))
+		require.Equal(context.Canceled, err)
+		require.Equal(0, b.Len())
+
+		require.NotEmpty(filename)
+		_, err = os.Stat(filename)
+		require.True(os.IsNotExist(err), "file must be removed")
+	})
+}
+
+func TestBuffer_WriteToContext(t *testing.T) {
+	t.Run("progress", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		data := []byte(generateRandomString(2000))
+
+		b := NewBufferWithMaxMemorySize(700)
+		defer b.Close()
+
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		var (
+			last Progress
+			res  bytes.Buffer
+		)
+		n, err := b.WriteToContext(context.Background(), &res, WithProgress(func(p Progress) {
+			require.True(p.Transferred > last.Transferred)
+			last = p
+		}))
+		require.Nil(err)
+		require.Equal(int64(len(data)), n)
+		require.Equal(data, res.Bytes())
+		require.Equal(Progress{Transferred: 2000, Memory: 700, Disk: 1300}, last)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		data := []byte(generateRandomString(2000))
+
+		b := NewBufferWithMaxMemorySize(700)
+		defer b.Close()
+
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var res bytes.Buffer
+		n, err := b.WriteToContext(ctx, &res, WithProgress(func(p Progress) {
+			if p.Transferred >= 1000 {
+				cancel()
+			}
+		}))
+		require.Equal(context.Canceled, err)
+		require.Equal(int64(1024), n)
+		require.Equal(data[:1024], res.Bytes())
+		require.Equal(len(data)-1024, b.Len())
+	})
+}
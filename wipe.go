@@ -0,0 +1,78 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// EnableSecureWipe enables secure mode: the memory and the encryption key are zeroed on Buffer.Reset()
+// and Buffer.Close(), discarded bytes are zeroed on Buffer.Truncate(). A new encryption key is generated
+// for the next temp file. If wipeFile is true, plain (unencrypted) temp files are overwritten with zeros
+// before removal.
+//
+// Readers returned by Buffer.NewReader() get their own copy of the memory. The copy is zeroed on Reader.Close()
+func (b *Buffer) EnableSecureWipe(wipeFile bool) {
+	b.secureWipe = true
+	b.wipeFile = wipeFile
+}
+
+// wipeMemory zeroes the memory and the encryption key
+func (b *Buffer) wipeMemory() {
+	data := b.buff.Bytes()
+	wipe(data[:cap(data)])
+	wipe(b.ringMemory)
+	wipe(b.encryptionKey)
+	b.encryptionKeyWiped = true
+}
+
+// writeToMemory writes data into bytes.Buffer. In secure mode it grows the buffer manually
+// to zero the old memory
+func (b *Buffer) writeToMemory(data []byte) (int, error) {
+	if b.secureWipe && b.buff.Cap()-b.buff.Len() < len(data) {
+		old := b.buff.Bytes()
+
+		newBuff := make([]byte, len(old), 2*cap(old)+len(data))
+		copy(newBuff, old)
+		wipe(old[:cap(old)])
+
+		b.buff = *bytes.NewBuffer(newBuff)
+	}
+
+	return b.buff.Write(data)
+}
+
+// wipeFileRange overwrites bytes [from, to) of the file with zeros
+func wipeFileRange(file TempFile, from, to int64) error {
+	zeros := make([]byte, 32<<10)
+	for off := from; off < to; {
+		chunk := zeros
+		if rest := to - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		n, err := file.WriteAt(chunk, off)
+		if err != nil {
+			return errors.Wrapf(err, "can't wipe a temp file '%s'", file.Name())
+		}
+		off += int64(n)
+	}
+
+	return file.Sync()
+}
+
+// wipeFileContent overwrites the whole file with zeros
+func wipeFileContent(file TempFile) error {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "can't get size of a temp file '%s'", file.Name())
+	}
+	return wipeFileRange(file, 0, size)
+}
+
+func wipe(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
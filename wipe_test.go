@@ -0,0 +1,138 @@
+package buffer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func isZeroed(data []byte) bool {
+	return bytes.Count(data, []byte{0}) == len(data)
+}
+
+func TestBuffer_EnableSecureWipe(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(1 << 10)
+		b.EnableSecureWipe(false)
+		defer b.Close()
+
+		_, err := b.Write([]byte(generateRandomString(100)))
+		require.Nil(err)
+
+		firstMemory := b.buff.Bytes()
+		firstMemory = firstMemory[:cap(firstMemory)]
+
+		// Grow the buffer
+		_, err = b.Write([]byte(generateRandomString(900)))
+		require.Nil(err)
+		require.True(isZeroed(firstMemory), "old memory must be zeroed")
+
+		memory := b.buff.Bytes()
+		memory = memory[:cap(memory)]
+
+		require.Nil(b.Truncate(500))
+		require.True(isZeroed(memory[500:]), "truncated memory must be zeroed")
+		require.False(isZeroed(memory[:500]))
+
+		b.Reset()
+		require.True(isZeroed(memory), "memory must be zeroed")
+	})
+
+	t.Run("encryption key", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.EnableEncryption())
+		b.EnableSecureWipe(false)
+		defer b.Close()
+
+		data := []byte(generateRandomString(100))
+
+		_, err := b.Write(data)
+		require.Nil(err)
+		key := append([]byte(nil), b.encryptionKey...)
+
+		b.Reset()
+		require.True(isZeroed(b.encryptionKey), "key must be zeroed")
+
+		// A new key must be generated
+		_, err = b.Write(data)
+		require.Nil(err)
+		require.False(isZeroed(b.encryptionKey))
+		require.NotEqual(key, b.encryptionKey)
+
+		res := readByChunks(require, b, 10)
+		require.Equal(data, res)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		dir, err := ioutil.TempDir("", "go-disk-buffer-test-")
+		require.Nil(err)
+		defer os.RemoveAll(dir)
+
+		b := NewBufferWithMaxMemorySize(10)
+		require.Nil(b.ChangeTempDir(dir))
+		b.EnableSecureWipe(true)
+		defer b.Close()
+
+		_, err = b.Write([]byte(generateRandomString(1000)))
+		require.Nil(err)
+
+		// Use a hard link to check the content after the removal
+		link := filepath.Join(dir, "link")
+		require.Nil(os.Link(b.filename, link))
+
+		require.Nil(b.Truncate(500))
+		data, err := ioutil.ReadFile(link)
+		require.Nil(err)
+		require.Len(data, 490)
+
+		require.Nil(b.Close())
+
+		data, err = ioutil.ReadFile(link)
+		require.Nil(err)
+		require.Len(data, 490)
+		require.True(isZeroed(data), "file must be zeroed")
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		t.Parallel()
+
+		require := require.New(t)
+
+		b := NewBufferWithMaxMemorySize(100)
+		b.EnableSecureWipe(false)
+		defer b.Close()
+
+		data := []byte(generateRandomString(50))
+		_, err := b.Write(data)
+		require.Nil(err)
+
+		r, err := b.NewReader()
+		require.Nil(err)
+
+		memory := r.memory
+		b.Reset()
+
+		res, err := ioutil.ReadAll(r)
+		require.Nil(err)
+		require.Equal(data, res)
+
+		require.Nil(r.Close())
+		require.True(isZeroed(memory), "memory of Reader must be zeroed")
+	})
+}